@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"os"
+	"strings"
+)
+
+// maxAltTextLength caps the alt text stored for each image, in characters
+const maxAltTextLength = 500
+
+// Attachment is an image attached to a post
+type Attachment struct {
+	ID            int
+	PostID        int
+	ImagePath     string
+	ThumbnailPath string
+	AltText       string
+	Width         int
+	Height        int
+	Position      int // Display order within the post, starting at 0
+}
+
+var errTooManyAttachments = errors.New("too many images attached")
+
+// storeAttachments validates and saves uploaded images in the order they were
+// sent, pairing each with the alt text at the same index. Nothing is written
+// to the database; the caller links the result to a post with
+// insertAttachments.
+func storeAttachments(files []*multipart.FileHeader, altTexts []string) ([]Attachment, error) {
+	if len(files) > MaxPostAttachments {
+		return nil, fmt.Errorf("%w: at most %d images are allowed", errTooManyAttachments, MaxPostAttachments)
+	}
+
+	attachments := make([]Attachment, 0, len(files))
+	for i, header := range files {
+		if header.Size > maxImageSize {
+			removeStoredAttachments(attachments)
+			return nil, errImageTooLarge
+		}
+		file, err := header.Open()
+		if err != nil {
+			removeStoredAttachments(attachments)
+			return nil, err
+		}
+		stored, err := saveUploadedImage(file)
+		file.Close()
+		if err != nil {
+			removeStoredAttachments(attachments)
+			return nil, err
+		}
+
+		var alt string
+		if i < len(altTexts) {
+			alt = strings.TrimSpace(altTexts[i])
+			alt = truncateRunes(alt, maxAltTextLength)
+		}
+		attachments = append(attachments, Attachment{
+			ImagePath:     stored.Path,
+			ThumbnailPath: stored.ThumbnailPath,
+			AltText:       alt,
+			Width:         stored.Width,
+			Height:        stored.Height,
+			Position:      i,
+		})
+	}
+	return attachments, nil
+}
+
+// removeStoredAttachments deletes the files of attachments that never made it
+// into a post. Identical uploads share one file, so files that something else
+// still refers to are kept.
+func removeStoredAttachments(attachments []Attachment) {
+	for _, a := range attachments {
+		for _, path := range []string{a.ImagePath, a.ThumbnailPath} {
+			var inUse bool
+			err := db.QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM post_attachments WHERE image_path = ?1 OR thumbnail_path = ?1)
+					OR EXISTS(SELECT 1 FROM posts WHERE image_path = ?1 OR thumbnail_path = ?1)
+					OR EXISTS(SELECT 1 FROM link_previews WHERE image_path = ?1)
+					OR EXISTS(SELECT 1 FROM communities WHERE icon = ?1)`, path).Scan(&inUse)
+			if err != nil {
+				log.Printf("Error checking whether %s is in use: %v", path, err)
+				continue
+			}
+			if inUse {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error removing unused image %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// insertAttachments links stored images to a post
+func insertAttachments(tx *sql.Tx, postID int64, attachments []Attachment) error {
+	for _, a := range attachments {
+		_, err := tx.Exec(`
+			INSERT INTO post_attachments (post_id, image_path, thumbnail_path, alt_text, width, height, position)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			postID, a.ImagePath, a.ThumbnailPath, a.AltText, a.Width, a.Height, a.Position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAttachments fills in the attachments of every post with a single query.
+// ImagePath and ThumbnailPath are set from the first image so clients that
+// only know about one image per post keep working.
+func loadAttachments(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(posts))
+	args := make([]interface{}, len(posts))
+	for i, post := range posts {
+		index[post.ID] = i
+		args[i] = post.ID
+		posts[i].Attachments = []Attachment{}
+	}
+
+	rows, err := db.Query(`
+		SELECT id, post_id, image_path, thumbnail_path, alt_text, width, height, position
+		FROM post_attachments
+		WHERE post_id IN (?`+strings.Repeat(",?", len(posts)-1)+`)
+		ORDER BY post_id, position, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.PostID, &a.ImagePath, &a.ThumbnailPath, &a.AltText, &a.Width, &a.Height, &a.Position); err != nil {
+			return err
+		}
+		post := &posts[index[a.PostID]]
+		if len(post.Attachments) == 0 {
+			post.ImagePath = a.ImagePath
+			post.ThumbnailPath = a.ThumbnailPath
+		}
+		post.Attachments = append(post.Attachments, a)
+	}
+	return rows.Err()
+}
+
+// migrateLegacyImages moves the single image stored in posts.image_path into
+// post_attachments. It only touches posts that have no attachments yet, so
+// it is safe to run on every start.
+func migrateLegacyImages() error {
+	_, err := db.Exec(`
+		INSERT INTO post_attachments (post_id, image_path, thumbnail_path, position)
+		SELECT p.id, p.image_path, COALESCE(p.thumbnail_path, ''), 0
+		FROM posts p
+		WHERE COALESCE(p.image_path, '') != ''
+		AND NOT EXISTS (SELECT 1 FROM post_attachments a WHERE a.post_id = p.id)`)
+	return err
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// notBlockedByCondition holds for a user, referred to as users, who hasn't
+// blocked the user whose ID it takes
+const notBlockedByCondition = "NOT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = users.id AND blocked_id = ?)"
+
+// BlockHandler blocks a user on POST and unblocks them on DELETE. A blocked
+// user's mentions of the blocker don't notify them.
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := GetUserIdFromSession(w, r)
+	if viewerID == "" {
+		respondWithError(w, "Please log in to block users", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if userID == viewerID {
+		respondWithError(w, "You cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		_, err = db.Exec("INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)",
+			viewerID, userID, time.Now())
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?", viewerID, userID)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating block: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"blocked": r.Method == http.MethodPost,
+	})
+}
@@ -234,6 +234,22 @@ func handleIncomingMessages(client *Client) {
 			TempID:      msgData["temp_id"].(string),
 		}
 
+		reason, err := requireReputation(client.UserID, KarmaToSendMessages, "send direct messages")
+		if err != nil {
+			log.Printf("Karma check error: %v", err)
+			continue
+		} else if reason != "" {
+			client.writeMu.Lock()
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":         "message_error",
+				"temp_id":      msg.TempID,
+				"recipient_id": msg.RecipientID,
+				"error":        reason,
+			})
+			client.writeMu.Unlock()
+			continue
+		}
+
 		res, err := db.Exec(`
 			INSERT INTO messages (sender_id, recipient_id, content, created_at, is_read)
 			VALUES (?, ?, ?, ?, ?)`,
@@ -246,10 +262,18 @@ func handleIncomingMessages(client *Client) {
 		id, _ := res.LastInsertId()
 		msg.ID = int(id)
 
+		mentioned, mentions, err := chatMentions(msg.Content, msg.RecipientID)
+		if err != nil {
+			log.Printf("Mention lookup error: %v", err)
+		} else if err := recordMentions(mentionSource{messageID: id}, msg.SenderID, mentioned); err != nil {
+			log.Printf("Mention save error: %v", err)
+		}
+		msg.Mentions = mentions
+
 		var username string
 		var avatar sql.NullString
-		if err := db.QueryRow("SELECT username, avatar_url FROM users WHERE id = ?", msg.SenderID).
-			Scan(&username, &avatar); err == nil {
+		if err := db.QueryRow("SELECT username, avatar_url, post_karma + comment_karma FROM users WHERE id = ?", msg.SenderID).
+			Scan(&username, &avatar, &msg.SenderKarma); err == nil {
 			msg.SenderUsername = username
 			if avatar.Valid {
 				msg.SenderAvatar = avatar.String
@@ -279,6 +303,7 @@ func ChatUsersHandler(w http.ResponseWriter, r *http.Request) {
 			u.id, 
 			u.username, 
 			COALESCE(u.avatar_url, '') as avatar_url,
+			u.post_karma + u.comment_karma as karma,
 			COALESCE(us.is_online, FALSE) as is_online,
 			datetime(COALESCE(us.last_seen, CURRENT_TIMESTAMP)) as last_seen,
 			COALESCE((
@@ -317,6 +342,7 @@ func ChatUsersHandler(w http.ResponseWriter, r *http.Request) {
 		ID              string    `json:"id"`
 		Username        string    `json:"username"`
 		AvatarURL       string    `json:"avatar_url"`
+		Karma           int       `json:"karma"`
 		IsOnline        bool      `json:"is_online"`
 		LastSeen        time.Time `json:"last_seen"`
 		LastMessage     string    `json:"last_message"`
@@ -333,6 +359,7 @@ func ChatUsersHandler(w http.ResponseWriter, r *http.Request) {
 			&u.ID,
 			&u.Username,
 			&u.AvatarURL,
+			&u.Karma,
 			&u.IsOnline,
 			&lastSeen,
 			&u.LastMessage,
@@ -401,6 +428,7 @@ func ChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 			m.is_read,
 			u.username,
 			COALESCE(u.avatar_url, ''),
+			u.post_karma + u.comment_karma,
 			CASE WHEN m.sender_id = ? THEN 1 ELSE 0 END
 		FROM messages m
 		JOIN users u ON m.sender_id = u.id
@@ -435,6 +463,7 @@ func ChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 			&msg.IsRead,
 			&msg.SenderUsername,
 			&avatar,
+			&msg.SenderKarma,
 			&isOwner,
 		); err != nil {
 			continue
@@ -459,6 +488,9 @@ func ChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	if err := loadMessageMentions(messages); err != nil {
+		log.Printf("Mention load error: %v", err)
+	}
 
 	json.NewEncoder(w).Encode(messages)
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -63,6 +64,20 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reason, err := lockReason(request.PostID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, `{"error":"Post not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Post lock check error:", err)
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	if reason != "" {
+		http.Error(w, `{"error":"`+reason+`"}`, http.StatusForbidden)
+		return
+	}
+
 	// Start a transaction
 	tx, err := db.Begin()
 	if err != nil {
@@ -72,10 +87,13 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	contentHTML, mentioned := renderWithMentions(request.Content)
+	var result sql.Result
 	if request.ParentID != nil {
 		// Verify that the parent comment exists and belongs to the same post
 		var parentPostID int
-		err = tx.QueryRow("SELECT post_id FROM comments WHERE id = ?", *request.ParentID).Scan(&parentPostID)
+		var parentDeleted bool
+		err = tx.QueryRow("SELECT post_id, deleted_at IS NOT NULL FROM comments WHERE id = ?", *request.ParentID).Scan(&parentPostID, &parentDeleted)
 		if err == sql.ErrNoRows {
 			http.Error(w, `{"error":"Parent comment not found"}`, http.StatusNotFound)
 			return
@@ -89,17 +107,21 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error":"Parent comment doesn't belong to this post"}`, http.StatusBadRequest)
 			return
 		}
+		if parentDeleted {
+			http.Error(w, `{"error":"Can't reply to a deleted comment"}`, http.StatusBadRequest)
+			return
+		}
 
 		// Insert reply
-		_, err = tx.Exec(
-			"INSERT INTO comments (post_id, user_id, content, parent_id, created_at) VALUES (?, ?, ?, ?, ?)",
-			request.PostID, userID, request.Content, *request.ParentID, time.Now(),
+		result, err = tx.Exec(
+			"INSERT INTO comments (post_id, user_id, content, content_html, parent_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			request.PostID, userID, request.Content, contentHTML, *request.ParentID, time.Now(),
 		)
 	} else {
 		// Insert top-level comment
-		_, err = tx.Exec(
-			"INSERT INTO comments (post_id, user_id, content, created_at) VALUES (?, ?, ?, ?)",
-			request.PostID, userID, request.Content, time.Now(),
+		result, err = tx.Exec(
+			"INSERT INTO comments (post_id, user_id, content, content_html, created_at) VALUES (?, ?, ?, ?, ?)",
+			request.PostID, userID, request.Content, contentHTML, time.Now(),
 		)
 	}
 
@@ -116,6 +138,12 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Notify the users it mentions
+	commentID, _ := result.LastInsertId()
+	if err := recordMentions(mentionSource{postID: int64(request.PostID), commentID: commentID}, userID, mentioned); err != nil {
+		log.Println("Record mentions error:", err)
+	}
+
 	// Return success response
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -124,7 +152,9 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetCommentsHandler handles GET requests for fetching comments
+// GetCommentsHandler handles GET requests for fetching a page of comments:
+// the first limit top-level comments after cursor, or with parent_id the
+// replies to that comment, each with its first few replies.
 func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Content-Type", "application/json")
@@ -154,17 +184,72 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-	comments, err := GetCommentsForPost(postID)
+	// Drafts and scheduled posts have no public comments, and NSFW posts
+	// none for viewers they are hidden from
+	viewerID := GetUserIdFromSession(w, r)
+	if visible, err := postVisible(postID, viewerID); err != nil {
+		log.Printf("Error checking post %d: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load comments"})
+		return
+	} else if !visible {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Post not found"})
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort, err = postCommentSort(postID)
+		if err != nil {
+			log.Printf("Error reading comment sort for post %d: %v", postID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load comments"})
+			return
+		}
+	} else if !validCommentSort(sort) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "sort must be best, top, new, old or controversial"})
+		return
+	}
+
+	_, limit, _ := parsePagination(r, CommentPageSize, maxCommentPageSize)
+	cursor := r.URL.Query().Get("cursor")
+
+	// parent_id loads more replies, or continues a thread cut off at the
+	// maximum depth
+	var page CommentPage
+	if parentIDStr := r.URL.Query().Get("parent_id"); parentIDStr != "" {
+		var parentID, parentPostID int
+		parentID, err = strconv.Atoi(parentIDStr)
+		if err == nil {
+			err = db.QueryRow("SELECT post_id FROM comments WHERE id = ?", parentID).Scan(&parentPostID)
+		}
+		if err != nil || parentPostID != postID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid parent comment"})
+			return
+		}
+		page, err = loadCommentPage("c.parent_id = ?", parentID, sort, cursor, limit)
+	} else {
+		page, err = loadCommentPage("c.post_id = ? AND c.parent_id IS NULL", postID, sort, cursor, limit)
+	}
+	if errors.Is(err, errInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid cursor"})
+		return
+	}
     if err != nil {
         log.Printf("Error fetching comments for post %d: %v", postID, err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load comments"})
         return
     }
+	markCommentsForViewer(page.Comments, postID, viewerID)
 
 
 	 // Return comments
-	 if err := json.NewEncoder(w).Encode(comments); err != nil {
+	 if err := json.NewEncoder(w).Encode(page); err != nil {
         log.Printf("Error encoding comments: %v", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to format response"})
@@ -177,120 +262,32 @@ func jsonError(w http.ResponseWriter, message string, statusCode int) {
         "error": message,
     })
 }
-// Fetch comments for a specific post
+// Fetch comments for a specific post as a reply tree in the post's default
+// sort. Branches deeper than MaxCommentDepth are cut off and marked
+// ContinueThread.
 var GetCommentsForPost = func(postID int) ([]Comment, error) {
-	// First, get all comments for this post
-	rows, err := db.Query(`
-		SELECT 
-			c.id, 
-			c.post_id,
-			c.user_id,
-			c.content,
-			c.created_at,
-			u.username,
-			c.parent_id,
-			(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) as reply_count,
-			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1) as like_count,
-			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 0) as dislike_count
-		FROM comments c
-		JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ? AND c.parent_id IS NULL
-		ORDER BY c.created_at DESC
-	`, postID)
+	sort, err := postCommentSort(postID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var comments []Comment
-	for rows.Next() {
-		var comment Comment
-		var createdAt time.Time
-		err := rows.Scan(
-			&comment.ID,
-			&comment.PostID,
-			&comment.UserID,
-			&comment.Content,
-			&createdAt,
-			&comment.Username,
-			&comment.ParentID,
-			&comment.ReplyCount,
-			&comment.LikeCount,
-			&comment.DislikeCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Set the CreatedAt field and the human-readable time
-		comment.CreatedAt = createdAt
-		comment.CreatedAtHuman = TimeAgo(createdAt)
-
-		// Get replies for this comment
-		replies, err := GetCommentReplies(comment.ID)
-		if err != nil {
-			return nil, err
-		}
-		comment.Replies = replies
-
-		comments = append(comments, comment)
-	}
-
-	return comments, nil
+	return loadCommentThreads("c.post_id = ? AND c.parent_id IS NULL", postID, sort)
 }
 
-// Get replies for a specific comment
+// Get the replies beneath a specific comment, oldest first, as a tree of its
+// own; callers re-sort it as needed. This is how a thread cut off at MaxCommentDepth is continued.
 var GetCommentReplies = func(commentID int) ([]Comment, error) {
-	rows, err := db.Query(`
-		SELECT 
-			c.id, 
-			c.post_id,
-			c.user_id,
-			c.content,
-			c.created_at,
-			u.username,
-			c.parent_id,
-			0 as reply_count,
-			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1) as like_count,
-			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 0) as dislike_count
-		FROM comments c
-		JOIN users u ON c.user_id = u.id
-		WHERE c.parent_id = ?
-		ORDER BY c.created_at ASC
-	`, commentID)
+	return loadCommentThreads("c.parent_id = ?", commentID, "old")
+}
+
+// loadCommentThreads loads every comment matching rootCondition, with all
+// their replies, through the same loader as a comment page
+func loadCommentThreads(rootCondition string, arg int, sort string) ([]Comment, error) {
+	roots, err := commentStats(rootCondition, arg)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var replies []Comment
-	for rows.Next() {
-		var reply Comment
-		var createdAt time.Time
-		err := rows.Scan(
-			&reply.ID,
-			&reply.PostID,
-			&reply.UserID,
-			&reply.Content,
-			&createdAt,
-			&reply.Username,
-			&reply.ParentID,
-			&reply.ReplyCount,
-			&reply.LikeCount,
-			&reply.DislikeCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Set the CreatedAt field and the human-readable time
-		reply.CreatedAt = createdAt
-		reply.CreatedAtHuman = TimeAgo(createdAt)
-
-		replies = append(replies, reply)
-	}
-
-	return replies, nil
+	sortComments(roots, sort)
+	return loadCommentReplies(roots, sort, -1)
 }
 
 // Get user ID from session
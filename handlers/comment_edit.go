@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deletedCommentText stands in for the text and author of deleted comments,
+// which stay in the tree so their replies keep their place
+const deletedCommentText = "[deleted]"
+
+// CommentRevision is the text a comment had before one of its edits
+type CommentRevision struct {
+	Content     string    `json:"content"`
+	ContentHTML string    `json:"content_html"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+// applyDeleted blanks the author of a deleted comment. Its text was already
+// replaced when it was deleted.
+func applyDeleted(c *Comment) {
+	if c.Deleted {
+		c.UserID = ""
+		c.Username = deletedCommentText
+		c.AuthorKarma = 0
+	}
+}
+
+// markCommentPermissions sets CanEdit and CanDelete on comments and their
+// replies for viewerID; canModerate is whether the viewer moderates the post
+// they belong to.
+func markCommentPermissions(comments []Comment, viewerID string, canModerate bool) {
+	for i := range comments {
+		c := &comments[i]
+		own := viewerID != "" && c.UserID == viewerID
+		c.CanEdit = own && !c.Deleted
+		c.CanDelete = (own || canModerate) && !c.Deleted
+		markCommentPermissions(c.Replies, viewerID, canModerate)
+	}
+}
+
+// markCommentsForViewer sets what viewerID saved, voted and may do on
+// comments, all of which belong to postID. Failures are logged and leave the
+// flags unset.
+func markCommentsForViewer(comments []Comment, postID int, viewerID string) {
+	if err := markSavedComments(comments, viewerID); err != nil {
+		log.Printf("Error marking saved comments: %v", err)
+	}
+	if err := markCommentVotes(comments, viewerID); err != nil {
+		log.Printf("Error marking comment votes: %v", err)
+	}
+	canModerate := false
+	if viewerID != "" {
+		var err error
+		if canModerate, err = canModeratePost(postID, viewerID); err != nil {
+			log.Printf("Error checking moderator: %v", err)
+		}
+	}
+	markCommentPermissions(comments, viewerID, canModerate)
+}
+
+// commentIDFromPath parses the {id} path value
+func commentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	commentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || commentID <= 0 {
+		respondWithError(w, "Invalid comment ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return commentID, true
+}
+
+// CommentItemHandler edits comment {id} on PUT with {"content": text}, which
+// only its author may do, and deletes it on DELETE, which its author or a
+// moderator of its post may do. Edits made within CommentEditGraceSeconds of
+// posting replace the text silently; later ones keep the old text as a
+// revision and mark the comment as edited.
+func CommentItemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+	commentID, ok := commentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var authorID, content string
+	var postID int
+	var createdAt time.Time
+	var deleted bool
+	err := db.QueryRow("SELECT user_id, post_id, content, created_at, deleted_at IS NOT NULL FROM comments WHERE id = ?",
+		commentID).Scan(&authorID, &postID, &content, &createdAt, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading comment: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		deleteComment(w, commentID, postID, userID, authorID, deleted)
+		return
+	}
+
+	var request struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	request.Content = strings.TrimSpace(request.Content)
+	switch {
+	case authorID != userID:
+		respondWithError(w, "Only the author can edit a comment", http.StatusForbidden)
+		return
+	case deleted:
+		respondWithError(w, "Deleted comments can't be edited", http.StatusConflict)
+		return
+	case request.Content == "":
+		respondWithError(w, "Comment content cannot be empty", http.StatusBadRequest)
+		return
+	}
+	reason, err := lockReason(postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error checking post lock: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if reason != "" {
+		respondWithError(w, reason, http.StatusForbidden)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var editedAt *time.Time
+	if request.Content != content && now.Sub(createdAt) > time.Duration(CommentEditGraceSeconds)*time.Second {
+		editedAt = &now
+		if _, err := tx.Exec("INSERT INTO comment_revisions (comment_id, content, replaced_at) VALUES (?, ?, ?)",
+			commentID, content, now); err != nil {
+			log.Printf("Error saving comment revision: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	contentHTML, mentioned := renderWithMentions(request.Content)
+	if err := tx.QueryRow(`
+		UPDATE comments SET content = ?, content_html = ?, edited_at = COALESCE(?, edited_at)
+		WHERE id = ?
+		RETURNING edited_at`, request.Content, contentHTML, editedAt, commentID).Scan(&editedAt); err != nil {
+		log.Printf("Error updating comment: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing comment edit: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := recordMentions(mentionSource{postID: int64(postID), commentID: int64(commentID)}, userID, mentioned); err != nil {
+		log.Printf("Error recording mentions in comment %d: %v", commentID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"content":      request.Content,
+		"content_html": contentHTML,
+		"edited_at":    editedAt,
+	})
+}
+
+// deleteComment replaces the comment's text with deletedCommentText and
+// drops its revisions and mentions, keeping the row so its replies stay in
+// place.
+func deleteComment(w http.ResponseWriter, commentID, postID int, userID, authorID string, deleted bool) {
+	if authorID != userID {
+		allowed, err := canModeratePost(postID, userID)
+		if err != nil {
+			log.Printf("Error checking moderator: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		} else if !allowed {
+			respondWithError(w, "Only the author or a moderator can delete a comment", http.StatusForbidden)
+			return
+		}
+	}
+
+	if !deleted {
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE comments SET content = ?, content_html = ?, deleted_at = ? WHERE id = ?",
+			deletedCommentText, RenderMarkdown(deletedCommentText), time.Now(), commentID); err != nil {
+			log.Printf("Error deleting comment: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM comment_revisions WHERE comment_id = ?", commentID); err != nil {
+			log.Printf("Error deleting comment revisions: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM mentions WHERE comment_id = ?", commentID); err != nil {
+			log.Printf("Error deleting comment mentions: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing comment delete: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"deleted": true,
+	})
+}
+
+// CommentRevisionsHandler lists the earlier versions of comment {id}, most
+// recent first.
+func CommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	commentID, ok := commentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	mode, err := nsfwMode(GetUserIdFromSession(w, r))
+	if err != nil {
+		log.Printf("Error reading NSFW preference: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var exists bool
+	if err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE c.id = ? AND `+visiblePostCondition+`)`, commentID, mode).Scan(&exists); err != nil {
+		log.Printf("Error checking comment: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		respondWithError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT content, replaced_at FROM comment_revisions
+		WHERE comment_id = ?
+		ORDER BY replaced_at DESC, id DESC`, commentID)
+	if err != nil {
+		log.Printf("Error fetching comment revisions: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []CommentRevision{}
+	for rows.Next() {
+		var revision CommentRevision
+		if err := rows.Scan(&revision.Content, &revision.ReplacedAt); err != nil {
+			log.Printf("Error scanning comment revision: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		revision.ContentHTML = RenderMarkdown(revision.Content)
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error fetching comment revisions: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"revisions": revisions,
+	})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,12 +11,15 @@ import (
 
 // CommentLikeResponse is the response for like/dislike actions
 type CommentLikeResponse struct {
-	LikeCount    int   `json:"likeCount"`
-	DislikeCount int   `json:"dislikeCount"`
-	UserLiked    *bool `json:"userLiked"`
+	LikeCount    int    `json:"likeCount"`
+	DislikeCount int    `json:"dislikeCount"`
+	Score        int    `json:"score"`
+	UserLiked    *bool  `json:"userLiked"`
+	Vote         string `json:"vote"` // up, down or none
 }
 
-// CommentLikeHandler handles liking/disliking comments
+// CommentLikeHandler sets the user's vote on a comment to the vote form
+// value, up, down or none, and returns the comment's fresh counts.
 func CommentLikeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -29,10 +33,8 @@ func CommentLikeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse comment ID and like status from request
+	// Parse comment ID and vote from request
 	commentID := r.FormValue("comment_id")
-	isLike := r.FormValue("is_like")
-
 	if commentID == "" {
 		http.Error(w, "Comment ID is required", http.StatusBadRequest)
 		return
@@ -44,96 +46,36 @@ func CommentLikeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify comment exists
-	var exists bool
-	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM comments WHERE id = ?)", commentIDInt).Scan(&exists)
-	if err != nil {
-		log.Printf("Error checking comment existence: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		http.Error(w, "Comment not found", http.StatusNotFound)
-		return
-	}
-
-	isLikeBool := isLike == "true"
-
-	// Start transaction
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	vote, ok := parseVote(r)
+	if !ok {
+		http.Error(w, "vote must be up, down or none", http.StatusBadRequest)
 		return
 	}
-	defer tx.Rollback()
-
-	// Check if user has already liked/disliked this comment
-	var existingIsLike sql.NullBool
-	err = tx.QueryRow(
-		"SELECT is_like FROM comment_likes WHERE comment_id = ? AND user_id = ?",
-		commentIDInt, userID,
-	).Scan(&existingIsLike)
 
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error checking existing like: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	// Set the vote; deleted comments and comments on locked posts take none
+	result, reason, err := setVote(commentVotes, commentIDInt, userID, vote)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
-	}
-
-	if existingIsLike.Valid {
-		if existingIsLike.Bool == isLikeBool {
-			// Remove the like/dislike if clicking the same button
-			_, err = tx.Exec("DELETE FROM comment_likes WHERE comment_id = ? AND user_id = ?",
-				commentIDInt, userID)
-		} else {
-			// Update from like to dislike or vice versa
-			_, err = tx.Exec("UPDATE comment_likes SET is_like = ? WHERE comment_id = ? AND user_id = ?",
-				isLikeBool, commentIDInt, userID)
-		}
-	} else {
-		// Add new like/dislike
-		_, err = tx.Exec("INSERT INTO comment_likes (comment_id, user_id, is_like) VALUES (?, ?, ?)",
-			commentIDInt, userID, isLikeBool)
-	}
-
-	if err != nil {
+	} else if err != nil {
 		log.Printf("Error updating like status: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-
-	// Get updated counts and user's current like status
-	var response CommentLikeResponse
-	var userLiked sql.NullBool
-	err = tx.QueryRow(`
-		SELECT 
-			(SELECT COUNT(*) FROM comment_likes WHERE comment_id = ? AND is_like = 1),
-			(SELECT COUNT(*) FROM comment_likes WHERE comment_id = ? AND is_like = 0),
-			CASE 
-				WHEN EXISTS (SELECT 1 FROM comment_likes WHERE comment_id = ? AND user_id = ?)
-				THEN (SELECT is_like FROM comment_likes WHERE comment_id = ? AND user_id = ?)
-				ELSE NULL 
-			END
-	`, commentIDInt, commentIDInt, commentIDInt, userID, commentIDInt, userID).Scan(&response.LikeCount, &response.DislikeCount, &userLiked)
-
-	if err != nil {
-		log.Printf("Error getting updated counts: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
 		return
 	}
 
-	if userLiked.Valid {
-		response.UserLiked = &userLiked.Bool
-	} else {
-		response.UserLiked = nil
+	response := CommentLikeResponse{
+		LikeCount:    result.LikeCount,
+		DislikeCount: result.DislikeCount,
+		Score:        result.Score,
+		Vote:         result.Vote,
 	}
-
-	// Commit transaction
-	if err = tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+	if vote != voteNone {
+		isLike := vote == voteUp
+		response.UserLiked = &isLike
 	}
 
 	// Return response as JSON
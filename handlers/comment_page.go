@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxCommentPageSize caps the limit a client may ask for
+const maxCommentPageSize = 100
+
+// commentStatsChunk caps how many IDs a single query binds
+const commentStatsChunk = 500
+
+// errInvalidCursor is returned for a cursor that is not a comment among the
+// ones being paged through
+var errInvalidCursor = errors.New("invalid cursor")
+
+// CommentPage is one page of a comment listing
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor"` // Empty on the last page
+	HasMore    bool      `json:"has_more"`
+}
+
+// loadCommentPage loads one page of the comments matching rootCondition,
+// which refers to the comment as c and takes arg, in the named sort. The page
+// starts after the comment whose ID is cursor ("" for the first page) and
+// holds up to limit comments, at least one, each with its first
+// CommentReplyLimit replies down to MaxCommentDepth levels. Comments with replies left out carry
+// MoreReplies and a RepliesCursor for the next page of them.
+//
+// Only IDs and vote counts are read for the comments that don't make the
+// page, so a thread with thousands of comments costs little more than the
+// page itself.
+func loadCommentPage(rootCondition string, arg int, sort, cursor string, limit int) (CommentPage, error) {
+	limit = max(limit, 1)
+	roots, err := commentStats(rootCondition, arg)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	sortComments(roots, sort)
+
+	if cursor != "" {
+		after, err := strconv.Atoi(cursor)
+		if err != nil {
+			return CommentPage{}, errInvalidCursor
+		}
+		start := -1
+		for i, c := range roots {
+			if c.ID == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return CommentPage{}, errInvalidCursor
+		}
+		roots = roots[start:]
+	}
+
+	var page CommentPage
+	if len(roots) > limit {
+		roots = roots[:limit]
+		page.HasMore = true
+		page.NextCursor = strconv.Itoa(roots[limit-1].ID)
+	}
+	page.Comments, err = loadCommentReplies(roots, sort, max(CommentReplyLimit, 0))
+	if err != nil {
+		return CommentPage{}, err
+	}
+	if page.Comments == nil {
+		page.Comments = []Comment{}
+	}
+	return page, nil
+}
+
+// loadCommentReplies loads the content of roots, read by commentStats and
+// already in order, and walks down one level at a time beneath them to
+// MaxCommentDepth levels, keeping the first replyLimit replies of each
+// comment in the sort, or all of them when replyLimit is below zero. It
+// returns roots as a tree.
+func loadCommentReplies(roots []Comment, sort string, replyLimit int) ([]Comment, error) {
+	// flat keeps every level in order, which is the order buildCommentTree
+	// keeps siblings in.
+	flat := roots
+	level := roots
+	for depth := 1; depth < maxCommentDepth() && len(level) > 0; depth++ {
+		parentIDs := make([]interface{}, len(level))
+		for i, c := range level {
+			parentIDs[i] = c.ID
+		}
+		replies, err := commentReplyStats(parentIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		byParent := make(map[int][]Comment)
+		for _, reply := range replies {
+			reply.Depth = depth
+			byParent[*reply.ParentID] = append(byParent[*reply.ParentID], reply)
+		}
+		var next []Comment
+		for _, parent := range level {
+			siblings := byParent[parent.ID]
+			sortComments(siblings, sort)
+			if replyLimit >= 0 && len(siblings) > replyLimit {
+				siblings = siblings[:replyLimit]
+			}
+			next = append(next, siblings...)
+		}
+		flat = append(flat, next...)
+		level = next
+	}
+
+	if err := loadCommentContent(flat); err != nil {
+		return nil, err
+	}
+	kept := make(map[int]int)
+	for _, c := range flat {
+		if c.ParentID != nil {
+			kept[*c.ParentID]++
+		}
+	}
+	for i := range flat {
+		c := &flat[i]
+		c.ContinueThread = c.Depth == maxCommentDepth()-1 && c.ReplyCount > 0
+		if !c.ContinueThread && c.ReplyCount > kept[c.ID] {
+			c.MoreReplies = c.ReplyCount - kept[c.ID]
+		}
+	}
+	tree := buildCommentTree(flat)
+	for i := range tree {
+		setRepliesCursors(&tree[i])
+	}
+	return tree, nil
+}
+
+// setRepliesCursors points RepliesCursor at the last reply kept beneath each
+// comment that has more, or "" to start from the first when none were kept.
+func setRepliesCursors(c *Comment) {
+	if c.MoreReplies > 0 && len(c.Replies) > 0 {
+		c.RepliesCursor = strconv.Itoa(c.Replies[len(c.Replies)-1].ID)
+	}
+	for i := range c.Replies {
+		setRepliesCursors(&c.Replies[i])
+	}
+}
+
+// commentStats reads what sorting needs, and no more, for the comments
+// matching condition: their IDs, parents, times and vote and reply counts.
+func commentStats(condition string, args ...interface{}) ([]Comment, error) {
+	rows, err := db.Query(`
+		SELECT
+			c.id,
+			c.parent_id,
+			c.created_at,
+			c.comment_count,
+			c.like_count,
+			c.dislike_count
+		FROM comments c
+		WHERE `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.CreatedAt, &c.ReplyCount, &c.LikeCount, &c.DislikeCount); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// commentReplyStats is commentStats for the replies to the given comments
+func commentReplyStats(parentIDs []interface{}) ([]Comment, error) {
+	var replies []Comment
+	for len(parentIDs) > 0 {
+		chunk := parentIDs[:min(len(parentIDs), commentStatsChunk)]
+		parentIDs = parentIDs[len(chunk):]
+		batch, err := commentStats("c.parent_id IN (?"+strings.Repeat(",?", len(chunk)-1)+")", chunk...)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, batch...)
+	}
+	return replies, nil
+}
+
+// loadCommentContent fills in the text, author and edit state of comments
+// loaded by commentStats.
+func loadCommentContent(comments []Comment) error {
+	byID := make(map[int]*Comment, len(comments))
+	ids := make([]interface{}, 0, len(comments))
+	for i := range comments {
+		byID[comments[i].ID] = &comments[i]
+		ids = append(ids, comments[i].ID)
+	}
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), commentStatsChunk)]
+		ids = ids[len(chunk):]
+		rows, err := db.Query(`
+			SELECT c.id, c.post_id, c.user_id, c.content, c.content_html, u.username,
+				u.post_karma + u.comment_karma, c.edited_at, c.deleted_at IS NOT NULL
+			FROM comments c
+			JOIN users u ON c.user_id = u.id
+			WHERE c.id IN (?`+strings.Repeat(",?", len(chunk)-1)+`)`, chunk...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var content Comment
+			if err := rows.Scan(&id, &content.PostID, &content.UserID, &content.Content, &content.ContentHTML, &content.Username,
+				&content.AuthorKarma, &content.EditedAt, &content.Deleted); err != nil {
+				rows.Close()
+				return err
+			}
+			c := byID[id]
+			c.PostID, c.UserID, c.Username = content.PostID, content.UserID, content.Username
+			c.AuthorKarma = content.AuthorKarma
+			c.Content, c.ContentHTML = content.Content, content.ContentHTML
+			c.EditedAt, c.Deleted = content.EditedAt, content.Deleted
+			applyDeleted(c)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	for i := range comments {
+		comments[i].CreatedAtHuman = TimeAgo(comments[i].CreatedAt)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultCommentSort is used for posts whose communities set no default
+const defaultCommentSort = "best"
+
+// commentSorts maps each comment sort to a function reporting whether a
+// comes before b. Ties go to the older comment.
+var commentSorts = map[string]func(a, b *Comment) bool{
+	"best": func(a, b *Comment) bool {
+		return wilsonLowerBound(a.LikeCount, a.DislikeCount) > wilsonLowerBound(b.LikeCount, b.DislikeCount)
+	},
+	"top": func(a, b *Comment) bool {
+		return a.LikeCount-a.DislikeCount > b.LikeCount-b.DislikeCount
+	},
+	"new": func(a, b *Comment) bool {
+		return a.CreatedAt.After(b.CreatedAt)
+	},
+	"old": func(a, b *Comment) bool {
+		return false // the tie-break alone
+	},
+	"controversial": func(a, b *Comment) bool {
+		return controversy(a.LikeCount, a.DislikeCount) > controversy(b.LikeCount, b.DislikeCount)
+	},
+}
+
+// validCommentSort reports whether name is one of commentSorts
+func validCommentSort(name string) bool {
+	_, ok := commentSorts[name]
+	return ok
+}
+
+// wilsonLowerBound is the lower bound of the 95% Wilson score interval for
+// the share of likes. Unlike the raw ratio it ranks 40 likes to 2 above 2
+// likes to 0, because the first is far more certain.
+func wilsonLowerBound(likes, dislikes int) float64 {
+	n := float64(likes + dislikes)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.96
+	p := float64(likes) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// controversy is high for comments with many votes split close to evenly
+func controversy(likes, dislikes int) float64 {
+	if likes == 0 || dislikes == 0 {
+		return 0
+	}
+	magnitude := float64(likes + dislikes)
+	balance := float64(min(likes, dislikes)) / float64(max(likes, dislikes))
+	return math.Pow(magnitude, balance)
+}
+
+// sortComments orders sibling comments by the named sort, which must be
+// valid.
+func sortComments(comments []Comment, name string) {
+	less := commentSorts[name]
+	sort.SliceStable(comments, func(i, j int) bool {
+		a, b := &comments[i], &comments[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// postCommentSort returns the default comment sort for a post: that of the
+// first of its communities to set one, or defaultCommentSort.
+func postCommentSort(postID int) (string, error) {
+	var name string
+	err := db.QueryRow(`
+		SELECT COALESCE((
+			SELECT c.default_comment_sort FROM post_categories pc
+			JOIN communities c ON c.name = pc.category
+			WHERE pc.post_id = ? AND c.default_comment_sort != ''
+			ORDER BY pc.rowid
+			LIMIT 1
+		), '')`, postID).Scan(&name)
+	if err != nil {
+		return "", err
+	}
+	if !validCommentSort(name) {
+		return defaultCommentSort, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// How many ancestors a comment permalink shows by default and at most
+const (
+	defaultCommentContext = 3
+	maxCommentContext     = 10
+)
+
+// commentAncestors returns the IDs of up to levels ancestors of a comment,
+// the most distant first.
+func commentAncestors(commentID, levels int) ([]interface{}, error) {
+	rows, err := db.Query(`
+		WITH RECURSIVE up(id, parent_id, level) AS (
+			SELECT id, parent_id, 0 FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, up.level + 1
+			FROM comments c
+			JOIN up ON c.id = up.parent_id
+			WHERE up.level < ?
+		)
+		SELECT id FROM up WHERE level > 0 ORDER BY level DESC`, commentID, levels)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadCommentThread returns the comment with up to context of its ancestors
+// above it, each holding only the next one down as its reply, and a page of
+// its own replies below it in the named sort. The ancestors' ReplyCount still
+// counts all their replies; the rest are seen in the post's full thread.
+func loadCommentThread(commentID, context int, sort string) ([]Comment, error) {
+	page, err := loadCommentPage("c.id = ?", commentID, sort, "", 1)
+	if err != nil || len(page.Comments) == 0 {
+		return page.Comments, err
+	}
+
+	ids, err := commentAncestors(commentID, context)
+	if err != nil || len(ids) == 0 {
+		return page.Comments, err
+	}
+	ancestors, err := commentStats("c.id IN (?"+strings.Repeat(",?", len(ids)-1)+")", ids...)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCommentContent(ancestors); err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Comment, len(ancestors))
+	for _, c := range ancestors {
+		byID[c.ID] = c
+	}
+
+	// Build the chain from the bottom up so each ancestor wraps the one below
+	thread := page.Comments[0]
+	shiftCommentDepth(&thread, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		parent := byID[ids[i].(int)]
+		parent.Depth = i
+		parent.Replies = []Comment{thread}
+		thread = parent
+	}
+	return []Comment{thread}, nil
+}
+
+// shiftCommentDepth moves a comment and its replies down by levels
+func shiftCommentDepth(c *Comment, levels int) {
+	c.Depth += levels
+	for i := range c.Replies {
+		shiftCommentDepth(&c.Replies[i], levels)
+	}
+}
+
+// CommentThreadHandler serves a permalink to comment {id}: the post it
+// belongs to, without its comments, and the comment in its thread with up
+// to ?context=N ancestors above it and its replies below it, so a link can
+// jump straight to a reply deep in a thread. ?sort orders the replies.
+func CommentThreadHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	context := defaultCommentContext
+	if value := r.URL.Query().Get("context"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondWithError(w, "context must be a number of levels", http.StatusBadRequest)
+			return
+		}
+		context = min(n, maxCommentContext)
+	}
+
+	var postID int
+	err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading comment: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		if sort, err = postCommentSort(postID); err != nil {
+			log.Printf("Error reading comment sort for post %d: %v", postID, err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	} else if !validCommentSort(sort) {
+		respondWithError(w, "sort must be best, top, new, old or controversial", http.StatusBadRequest)
+		return
+	}
+
+	// The post must be one the viewer could see in a feed
+	viewerID := GetUserIdFromSession(w, r)
+	query := feedQuery{sort: "new", limit: 1}
+	query.and("p.id = ?", postID)
+	posts, _, err := queryFeed(query, viewerID)
+	if err != nil {
+		log.Printf("Error loading post %d: %v", postID, err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if len(posts) == 0 {
+		respondWithError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	comments, err := loadCommentThread(commentID, context, sort)
+	if err != nil {
+		log.Printf("Error loading comment thread %d: %v", commentID, err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	markCommentsForViewer(comments, postID, viewerID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"post":       posts[0],
+		"comments":   comments,
+		"comment_id": commentID,
+		"context":    context,
+	})
+}
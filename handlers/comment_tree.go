@@ -0,0 +1,41 @@
+package handlers
+
+// buildCommentTree nests comments under their parents, keeping their order.
+// Comments whose parent is not in the list are the roots.
+func buildCommentTree(flat []Comment) []Comment {
+	present := make(map[int]bool, len(flat))
+	for _, c := range flat {
+		present[c.ID] = true
+	}
+	children := make(map[int][]int)
+	var roots []int
+	for i, c := range flat {
+		if c.ParentID != nil && present[*c.ParentID] {
+			children[*c.ParentID] = append(children[*c.ParentID], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	var build func(indexes []int) []Comment
+	build = func(indexes []int) []Comment {
+		if len(indexes) == 0 {
+			return nil
+		}
+		comments := make([]Comment, len(indexes))
+		for i, index := range indexes {
+			comments[i] = flat[index]
+			comments[i].Replies = build(children[comments[i].ID])
+		}
+		return comments
+	}
+	return build(roots)
+}
+
+// maxCommentDepth is MaxCommentDepth with a floor of one level
+func maxCommentDepth() int {
+	if MaxCommentDepth < 1 {
+		return 1
+	}
+	return MaxCommentDepth
+}
@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultCommunities are the topics the forum started with as a fixed
+// category list. They are seeded on startup with no owner so existing posts,
+// whose categories are these names, keep working.
+var defaultCommunities = []string{
+	"technology",
+	"general",
+	"lifestyle",
+	"entertainment",
+	"gaming",
+	"food",
+	"business",
+	"religion",
+	"health",
+	"music",
+	"sports",
+	"beauty",
+	"jobs",
+}
+
+const (
+	maxCommunityDescription = 500
+	maxCommunityRules       = 5000
+)
+
+// Community names double as the category stored on each post, so they are
+// restricted to short lowercase slugs.
+var communityNamePattern = regexp.MustCompile(`^[a-z0-9_]{3,21}$`)
+
+var errUnknownCommunity = errors.New("unknown community")
+
+// Community is a topic area that posts are filed under
+type Community struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rules       string    `json:"rules"`
+	Icon        string    `json:"icon"`
+	Owner       string    `json:"owner"` // Empty for the built-in communities
+	Moderators  []string  `json:"moderators"`
+	Subscribers int       `json:"subscribers"`
+	Posts       int       `json:"posts"`
+	Subscribed  bool      `json:"subscribed"`
+	CommentSort string    `json:"default_comment_sort"` // Empty for the site default
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// seedDefaultCommunities makes sure every built-in community exists
+func seedDefaultCommunities() error {
+	for _, name := range defaultCommunities {
+		if _, err := db.Exec("INSERT OR IGNORE INTO communities (name) VALUES (?)", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// communityExists reports whether name is a community
+func communityExists(name string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM communities WHERE name = ?)", name).Scan(&exists)
+	return exists, err
+}
+
+// checkCommunities returns errUnknownCommunity unless every name, after
+// normalizeCategories, is an existing community.
+func checkCommunities(names []string) error {
+	for _, name := range normalizeCategories(names) {
+		exists, err := communityExists(name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errUnknownCommunity
+		}
+	}
+	return nil
+}
+
+// isSiteAdmin reports whether the user has the admin role. Admins are made
+// directly in the database: UPDATE users SET role = 'admin' WHERE ...
+func isSiteAdmin(userID string) bool {
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		return false
+	}
+	return role == "admin"
+}
+
+// isCommunityModerator reports whether the user moderates the community.
+// Site admins moderate every community.
+func isCommunityModerator(communityID int64, userID string) bool {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM community_moderators WHERE community_id = ? AND user_id = ?)", communityID, userID).Scan(&exists)
+	return (err == nil && exists) || isSiteAdmin(userID)
+}
+
+// canCreateCommunity decides whether a user may start a community. It
+// returns a user-facing reason when they may not.
+func canCreateCommunity(userID string) (bool, string, error) {
+	if isSiteAdmin(userID) {
+		return true, "", nil
+	}
+
+	var accountDays float64
+	var owned int
+	err := db.QueryRow(`
+		SELECT julianday('now') - julianday(created_at),
+			(SELECT COUNT(*) FROM communities WHERE owner_id = users.id)
+		FROM users WHERE id = ?`, userID).Scan(&accountDays, &owned)
+	if err != nil {
+		return false, "", err
+	}
+	if accountDays < float64(CommunityMinAccountDays) {
+		return false, "Your account is too new to create a community", nil
+	}
+	if owned >= MaxCommunitiesPerUser {
+		return false, "You already own the maximum number of communities", nil
+	}
+	if reason, err := requireReputation(userID, KarmaToCreateCommunity, "create a community"); err != nil || reason != "" {
+		return false, reason, err
+	}
+	return true, "", nil
+}
+
+// loadCommunities lists communities with their counts. An empty name lists
+// all of them; viewerID sets the Subscribed flag.
+func loadCommunities(name, viewerID string) ([]Community, error) {
+	query := `
+		SELECT c.id, c.name, c.description, c.rules, c.icon, c.default_comment_sort, COALESCE(u.username, ''), c.created_at,
+			(SELECT COUNT(*) FROM community_subscriptions s WHERE s.community_id = c.id),
+			(SELECT COUNT(*) FROM post_categories pc JOIN posts p ON p.id = pc.post_id
+				WHERE pc.category = c.name AND p.status = 'published'),
+			EXISTS(SELECT 1 FROM community_subscriptions s WHERE s.community_id = c.id AND s.user_id = ?)
+		FROM communities c
+		LEFT JOIN users u ON u.id = c.owner_id`
+	args := []interface{}{viewerID}
+	if name != "" {
+		query += " WHERE c.name = ?"
+		args = append(args, name)
+	}
+	query += " ORDER BY c.name"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	communities := []Community{}
+	for rows.Next() {
+		var c Community
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.Rules, &c.Icon, &c.CommentSort, &c.Owner, &c.CreatedAt,
+			&c.Subscribers, &c.Posts, &c.Subscribed); err != nil {
+			return nil, err
+		}
+		c.Moderators = []string{}
+		communities = append(communities, c)
+	}
+	return communities, rows.Err()
+}
+
+// CommunitiesHandler lists communities on GET and creates one on POST. A new
+// community takes a name, description, rules and an optional icon image as
+// form fields; its creator becomes owner, first moderator and subscriber.
+func CommunitiesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+
+	switch r.Method {
+	case http.MethodGet:
+		communities, err := loadCommunities("", userID)
+		if err != nil {
+			log.Printf("Error fetching communities: %v", err)
+			respondWithError(w, "Error fetching communities", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"communities": communities,
+		})
+
+	case http.MethodPost:
+		if userID == "" {
+			respondWithError(w, "Please log in to create a community", http.StatusUnauthorized)
+			return
+		}
+		allowed, reason, err := canCreateCommunity(userID)
+		if err != nil {
+			log.Printf("Error checking community eligibility: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			respondWithError(w, reason, http.StatusForbidden)
+			return
+		}
+
+		name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+		description := strings.TrimSpace(r.FormValue("description"))
+		rules := strings.TrimSpace(r.FormValue("rules"))
+		if !communityNamePattern.MatchString(name) {
+			respondWithError(w, "Community names are 3 to 21 lowercase letters, digits or underscores", http.StatusBadRequest)
+			return
+		}
+		if len(description) > maxCommunityDescription || len(rules) > maxCommunityRules {
+			respondWithError(w, "Description or rules are too long", http.StatusBadRequest)
+			return
+		}
+		icon, ok := communityIconFromForm(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec(
+			"INSERT INTO communities (name, description, rules, icon, owner_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			name, description, rules, icon, userID, time.Now())
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				respondWithError(w, "A community with that name already exists", http.StatusConflict)
+				return
+			}
+			log.Printf("Error creating community: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		communityID, _ := result.LastInsertId()
+		if _, err := tx.Exec("INSERT INTO community_moderators (community_id, user_id) VALUES (?, ?)", communityID, userID); err != nil {
+			log.Printf("Error adding community owner as moderator: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("INSERT INTO community_subscriptions (community_id, user_id) VALUES (?, ?)", communityID, userID); err != nil {
+			log.Printf("Error subscribing community owner: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"name":    name,
+		})
+
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// communityIconFromForm stores the optional "icon" upload and returns the
+// path of its thumbnail. It writes the error response itself and returns
+// false when the upload is rejected.
+func communityIconFromForm(w http.ResponseWriter, r *http.Request) (string, bool) {
+	file, _, err := r.FormFile("icon")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return "", true
+	} else if err != nil {
+		respondWithError(w, "Invalid icon upload", http.StatusBadRequest)
+		return "", false
+	}
+	defer file.Close()
+
+	stored, err := saveUploadedImage(file)
+	if errors.Is(err, errImageTooLarge) || errors.Is(err, errInvalidImage) || errors.Is(err, errImageDimensions) {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	} else if err != nil {
+		log.Printf("Error saving community icon: %v", err)
+		respondWithError(w, "Error saving icon", http.StatusInternalServerError)
+		return "", false
+	}
+	return stored.ThumbnailPath, true
+}
+
+// formField returns the trimmed value of a form field and whether the form
+// has it at all, to tell a field left out from one sent empty
+func formField(r *http.Request, name string) (string, bool) {
+	value := strings.TrimSpace(r.FormValue(name))
+	_, ok := r.Form[name]
+	return value, ok
+}
+
+// communityFromPath loads the community named in the URL, writing a 404 when
+// there is none.
+func communityFromPath(w http.ResponseWriter, r *http.Request, viewerID string) (*Community, bool) {
+	communities, err := loadCommunities(strings.ToLower(r.PathValue("name")), viewerID)
+	if err != nil {
+		log.Printf("Error fetching community: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(communities) == 0 {
+		respondWithError(w, "Community not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &communities[0], true
+}
+
+// CommunityHandler returns one community with its moderators on GET, and lets
+// its moderators change the description, rules and icon on PUT.
+func CommunityHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	community, ok := communityFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+			SELECT u.username FROM community_moderators m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.community_id = ?
+			ORDER BY u.username`, community.ID)
+		if err != nil {
+			log.Printf("Error fetching moderators: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var username string
+			if err := rows.Scan(&username); err == nil {
+				community.Moderators = append(community.Moderators, username)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"community": community,
+		})
+
+	case http.MethodPut:
+		if userID == "" {
+			respondWithError(w, "Please log in", http.StatusUnauthorized)
+			return
+		}
+		if !isCommunityModerator(community.ID, userID) {
+			respondWithError(w, "Only moderators can edit this community", http.StatusForbidden)
+			return
+		}
+
+		// Fields left out of the form keep their value, so an icon can be
+		// changed on its own; an empty field clears it
+		description, rules := community.Description, community.Rules
+		if value, ok := formField(r, "description"); ok {
+			description = value
+		}
+		if value, ok := formField(r, "rules"); ok {
+			rules = value
+		}
+		if len(description) > maxCommunityDescription || len(rules) > maxCommunityRules {
+			respondWithError(w, "Description or rules are too long", http.StatusBadRequest)
+			return
+		}
+		icon, ok := communityIconFromForm(w, r)
+		if !ok {
+			return
+		}
+		if icon == "" {
+			icon = community.Icon
+		}
+		// An empty comment sort falls back to the site default
+		commentSort := community.CommentSort
+		if value, ok := formField(r, "default_comment_sort"); ok {
+			commentSort = value
+			if commentSort != "" && !validCommentSort(commentSort) {
+				respondWithError(w, "Unknown comment sort", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if _, err := db.Exec("UPDATE communities SET description = ?, rules = ?, icon = ?, default_comment_sort = ? WHERE id = ?",
+			description, rules, icon, commentSort, community.ID); err != nil {
+			log.Printf("Error updating community: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CommunitySubscriptionHandler subscribes the current user to a community on
+// POST and unsubscribes them on DELETE. Both are idempotent.
+func CommunitySubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to subscribe", http.StatusUnauthorized)
+		return
+	}
+	community, ok := communityFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		_, err = db.Exec("INSERT OR IGNORE INTO community_subscriptions (community_id, user_id) VALUES (?, ?)", community.ID, userID)
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM community_subscriptions WHERE community_id = ? AND user_id = ?", community.ID, userID)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating subscription: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var subscribers int
+	db.QueryRow("SELECT COUNT(*) FROM community_subscriptions WHERE community_id = ?", community.ID).Scan(&subscribers)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"subscribed":  r.Method == http.MethodPost,
+		"subscribers": subscribers,
+	})
+}
+
+// CommunityModeratorsHandler lets a community's owner, or a site admin, add
+// (POST) or remove (DELETE) a moderator by username. The owner always stays
+// a moderator.
+func CommunityModeratorsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+	community, ok := communityFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var ownerID sql.NullString
+	if err := db.QueryRow("SELECT owner_id FROM communities WHERE id = ?", community.ID).Scan(&ownerID); err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if ownerID.String != userID && !isSiteAdmin(userID) {
+		respondWithError(w, "Only the owner can manage moderators", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.Username) == "" {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	var moderatorID string
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", strings.TrimSpace(request.Username)).Scan(&moderatorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		_, err = db.Exec("INSERT OR IGNORE INTO community_moderators (community_id, user_id) VALUES (?, ?)", community.ID, moderatorID)
+	case http.MethodDelete:
+		if moderatorID == ownerID.String {
+			respondWithError(w, "The owner cannot be removed as moderator", http.StatusBadRequest)
+			return
+		}
+		_, err = db.Exec("DELETE FROM community_moderators WHERE community_id = ? AND user_id = ?", community.ID, moderatorID)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating moderators: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
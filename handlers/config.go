@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Tunables read from the environment at startup. The defaults suit a small
+// community; set the matching FORUM_* variable to override one.
+var (
+	// MaxPostAttachments is how many images a single post may carry. Zero
+	// turns attachments off.
+	MaxPostAttachments = max(envInt("FORUM_MAX_ATTACHMENTS", 10), 0)
+
+	// SchedulerIntervalSeconds is how often scheduled posts are checked
+	SchedulerIntervalSeconds = envPositiveInt("FORUM_SCHEDULER_INTERVAL", 30)
+
+	// LinkPreviewTimeoutSeconds bounds the whole fetch of one link preview
+	LinkPreviewTimeoutSeconds = envPositiveInt("FORUM_LINK_PREVIEW_TIMEOUT", 10)
+
+	// CommunityMinAccountDays is how old an account must be to create a
+	// community, and MaxCommunitiesPerUser caps how many one user may own.
+	// Site admins are exempt from both.
+	CommunityMinAccountDays = envInt("FORUM_COMMUNITY_MIN_ACCOUNT_DAYS", 7)
+	MaxCommunitiesPerUser   = envInt("FORUM_MAX_COMMUNITIES_PER_USER", 5)
+
+	// MaxCommentDepth is how many levels of a comment thread are loaded at
+	// once; deeper replies are fetched by continuing the thread
+	MaxCommentDepth = envInt("FORUM_COMMENT_MAX_DEPTH", 8)
+
+	// CommentPageSize is how many top-level comments, or replies when
+	// loading more of them, one page holds; CommentReplyLimit is how many
+	// replies each comment in a page comes with.
+	CommentPageSize   = envPositiveInt("FORUM_COMMENT_PAGE_SIZE", 20)
+	CommentReplyLimit = envInt("FORUM_COMMENT_REPLY_LIMIT", 3)
+
+	// CommentEditGraceSeconds is how long after posting a comment its author
+	// may edit it without it being marked as edited
+	CommentEditGraceSeconds = envInt("FORUM_COMMENT_EDIT_GRACE", 180)
+
+	// ArchiveAfterDays is the age at which posts are archived and stop
+	// accepting comments and votes; 0 never archives them
+	ArchiveAfterDays = envInt("FORUM_ARCHIVE_AFTER_DAYS", 180)
+
+	// The karma a user needs to create a community, post links and send
+	// direct messages; 0 lets anyone. Site admins need none.
+	KarmaToCreateCommunity = envInt("FORUM_KARMA_CREATE_COMMUNITY", 0)
+	KarmaToPostLinks       = envInt("FORUM_KARMA_LINK_POSTS", 0)
+	KarmaToSendMessages    = envInt("FORUM_KARMA_DIRECT_MESSAGES", 0)
+)
+
+// envInt reads an integer from the environment, falling back to def when the
+// variable is unset or not a number.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring %s=%q: not a number", name, value)
+		return def
+	}
+	return n
+}
+
+// envPositiveInt is envInt for values that must be above zero, such as
+// intervals and timeouts, falling back to def for zero or less.
+func envPositiveInt(name string, def int) int {
+	n := envInt(name, def)
+	if n <= 0 {
+		log.Printf("Ignoring %s=%d: must be above zero", name, n)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+package handlers
+
+// counterTriggers keep the vote and comment counters on posts and comments in
+// step with likes, comment_likes and comments, so reads don't have to count.
+// comment_count on a comment is its number of direct replies. Deleted
+// comments keep their row and so still count.
+const counterTriggers = `
+    CREATE TRIGGER IF NOT EXISTS likes_count_insert AFTER INSERT ON likes BEGIN
+        UPDATE posts SET
+            like_count = like_count + (NEW.is_like = 1),
+            dislike_count = dislike_count + (NEW.is_like = 0),
+            score = score + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = NEW.post_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS likes_count_delete AFTER DELETE ON likes BEGIN
+        UPDATE posts SET
+            like_count = like_count - (OLD.is_like = 1),
+            dislike_count = dislike_count - (OLD.is_like = 0),
+            score = score - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = OLD.post_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS likes_count_update AFTER UPDATE OF is_like, post_id ON likes BEGIN
+        UPDATE posts SET
+            like_count = like_count - (OLD.is_like = 1),
+            dislike_count = dislike_count - (OLD.is_like = 0),
+            score = score - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = OLD.post_id;
+        UPDATE posts SET
+            like_count = like_count + (NEW.is_like = 1),
+            dislike_count = dislike_count + (NEW.is_like = 0),
+            score = score + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = NEW.post_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_count_insert AFTER INSERT ON comment_likes BEGIN
+        UPDATE comments SET
+            like_count = like_count + (NEW.is_like = 1),
+            dislike_count = dislike_count + (NEW.is_like = 0),
+            score = score + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = NEW.comment_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_count_delete AFTER DELETE ON comment_likes BEGIN
+        UPDATE comments SET
+            like_count = like_count - (OLD.is_like = 1),
+            dislike_count = dislike_count - (OLD.is_like = 0),
+            score = score - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = OLD.comment_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_count_update AFTER UPDATE OF is_like, comment_id ON comment_likes BEGIN
+        UPDATE comments SET
+            like_count = like_count - (OLD.is_like = 1),
+            dislike_count = dislike_count - (OLD.is_like = 0),
+            score = score - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = OLD.comment_id;
+        UPDATE comments SET
+            like_count = like_count + (NEW.is_like = 1),
+            dislike_count = dislike_count + (NEW.is_like = 0),
+            score = score + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = NEW.comment_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comments_count_insert AFTER INSERT ON comments BEGIN
+        UPDATE posts SET comment_count = comment_count + 1 WHERE id = NEW.post_id;
+        UPDATE comments SET comment_count = comment_count + 1 WHERE id = NEW.parent_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comments_count_delete AFTER DELETE ON comments BEGIN
+        UPDATE posts SET comment_count = comment_count - 1 WHERE id = OLD.post_id;
+        UPDATE comments SET comment_count = comment_count - 1 WHERE id = OLD.parent_id;
+    END;
+`
+
+// RepairCounters recomputes every post's and comment's counters from likes,
+// comment_likes and comments, for when they have drifted or were never set.
+// It returns how many posts and comments it corrected.
+func RepairCounters() (posts, comments int64, err error) {
+	result, err := db.Exec(`
+		WITH actual AS (
+			SELECT p.id,
+				(SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id AND l.is_like = 1) AS likes,
+				(SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id AND l.is_like = 0) AS dislikes,
+				(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments
+			FROM posts p
+		)
+		UPDATE posts SET
+			like_count = a.likes,
+			dislike_count = a.dislikes,
+			score = a.likes - a.dislikes,
+			comment_count = a.comments
+		FROM actual a
+		WHERE a.id = posts.id AND (posts.like_count != a.likes OR posts.dislike_count != a.dislikes OR
+			posts.score != a.likes - a.dislikes OR posts.comment_count != a.comments)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	posts, _ = result.RowsAffected()
+
+	result, err = db.Exec(`
+		WITH actual AS (
+			SELECT c.id,
+				(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1) AS likes,
+				(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 0) AS dislikes,
+				(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS replies
+			FROM comments c
+		)
+		UPDATE comments SET
+			like_count = a.likes,
+			dislike_count = a.dislikes,
+			score = a.likes - a.dislikes,
+			comment_count = a.replies
+		FROM actual a
+		WHERE a.id = comments.id AND (comments.like_count != a.likes OR comments.dislike_count != a.dislikes OR
+			comments.score != a.likes - a.dislikes OR comments.comment_count != a.replies)`)
+	if err != nil {
+		return posts, 0, err
+	}
+	comments, _ = result.RowsAffected()
+	return posts, comments, nil
+}
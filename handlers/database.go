@@ -78,6 +78,207 @@ func InitDB() {
         FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
     );
 
+    CREATE TABLE IF NOT EXISTS post_attachments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        post_id INTEGER NOT NULL,
+        image_path TEXT NOT NULL,
+        thumbnail_path TEXT NOT NULL DEFAULT '',
+        alt_text TEXT NOT NULL DEFAULT '',
+        width INTEGER NOT NULL DEFAULT 0,
+        height INTEGER NOT NULL DEFAULT 0,
+        position INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS polls (
+        post_id INTEGER PRIMARY KEY,
+        multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+        hide_results BOOLEAN NOT NULL DEFAULT FALSE,  -- Counts stay hidden until the poll closes
+        closes_at DATETIME,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS poll_options (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        post_id INTEGER NOT NULL,
+        label TEXT NOT NULL,
+        position INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY(post_id) REFERENCES polls(post_id) ON DELETE CASCADE
+    );
+
+    -- One ballot per user and poll; its choices hang off it
+    CREATE TABLE IF NOT EXISTS poll_ballots (
+        post_id INTEGER NOT NULL,
+        user_id TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (post_id, user_id),
+        FOREIGN KEY(post_id) REFERENCES polls(post_id) ON DELETE CASCADE,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS poll_choices (
+        post_id INTEGER NOT NULL,
+        user_id TEXT NOT NULL,
+        option_id INTEGER NOT NULL,
+        PRIMARY KEY (post_id, user_id, option_id),
+        FOREIGN KEY(post_id, user_id) REFERENCES poll_ballots(post_id, user_id) ON DELETE CASCADE,
+        FOREIGN KEY(option_id) REFERENCES poll_options(id) ON DELETE CASCADE
+    );
+
+    CREATE TRIGGER IF NOT EXISTS poll_choices_single BEFORE INSERT ON poll_choices
+    WHEN (SELECT multiple_choice FROM polls WHERE post_id = NEW.post_id) = 0
+        AND EXISTS (SELECT 1 FROM poll_choices WHERE post_id = NEW.post_id AND user_id = NEW.user_id)
+    BEGIN
+        SELECT RAISE(ABORT, 'single choice poll');
+    END;
+
+    CREATE TABLE IF NOT EXISTS link_previews (
+        post_id INTEGER PRIMARY KEY,
+        url TEXT NOT NULL,
+        title TEXT NOT NULL DEFAULT '',
+        description TEXT NOT NULL DEFAULT '',
+        image_path TEXT NOT NULL DEFAULT '',     -- Local thumbnail of the page's OpenGraph image
+        status TEXT NOT NULL DEFAULT 'pending',  -- pending, ready or failed
+        fetched_at DATETIME,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE
+    );
+
+    -- Communities are the categories posts are filed under; post_categories
+    -- stores the community name
+    CREATE TABLE IF NOT EXISTS communities (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT UNIQUE NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        rules TEXT NOT NULL DEFAULT '',
+        icon TEXT NOT NULL DEFAULT '',
+        owner_id TEXT,                    -- NULL for the built-in communities
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(owner_id) REFERENCES users(id) ON DELETE SET NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS community_moderators (
+        community_id INTEGER NOT NULL,
+        user_id TEXT NOT NULL,
+        PRIMARY KEY (community_id, user_id),
+        FOREIGN KEY(community_id) REFERENCES communities(id) ON DELETE CASCADE,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS community_subscriptions (
+        community_id INTEGER NOT NULL,
+        user_id TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (community_id, user_id),
+        FOREIGN KEY(community_id) REFERENCES communities(id) ON DELETE CASCADE,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS follows (
+        follower_id TEXT NOT NULL,
+        followee_id TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (follower_id, followee_id),
+        FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(followee_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    -- Users who blocked someone aren't notified when that user mentions them
+    CREATE TABLE IF NOT EXISTS user_blocks (
+        blocker_id TEXT NOT NULL,
+        blocked_id TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (blocker_id, blocked_id),
+        FOREIGN KEY(blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(blocked_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS notifications (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,            -- Who is notified
+        type TEXT NOT NULL,
+        actor_id TEXT,                    -- Who caused it
+        post_id INTEGER,
+        comment_id INTEGER,
+        is_read BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE,
+        FOREIGN KEY(comment_id) REFERENCES comments(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS collections (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (user_id, name),
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS saved_items (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,
+        post_id INTEGER,                  -- Exactly one of post_id and comment_id is set
+        comment_id INTEGER,
+        collection_id INTEGER,            -- NULL when not filed in a collection
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        CHECK ((post_id IS NULL) != (comment_id IS NULL)),
+        UNIQUE (user_id, post_id),
+        UNIQUE (user_id, comment_id),
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE,
+        FOREIGN KEY(comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+        FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE SET NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS pinned_posts (
+        post_id INTEGER NOT NULL,
+        category TEXT NOT NULL,           -- Community the pin applies to, '' for site-wide
+        pinned_by TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (post_id, category),
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE,
+        FOREIGN KEY(pinned_by) REFERENCES users(id) ON DELETE SET NULL
+    );
+
+    -- The text a comment had before each edit made after the grace period
+    CREATE TABLE IF NOT EXISTS comment_revisions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        comment_id INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        replaced_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(comment_id) REFERENCES comments(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS mentions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,            -- Who is mentioned
+        actor_id TEXT NOT NULL,           -- Who mentioned them
+        post_id INTEGER,                  -- Exactly one of these says where
+        comment_id INTEGER,
+        message_id INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        CHECK ((post_id IS NOT NULL) + (comment_id IS NOT NULL) + (message_id IS NOT NULL) = 1),
+        UNIQUE (user_id, post_id),
+        UNIQUE (user_id, comment_id),
+        UNIQUE (user_id, message_id),
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE,
+        FOREIGN KEY(comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+        FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS hidden_posts (
+        user_id TEXT NOT NULL,
+        post_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, post_id),
+        FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE
+    );
+
     CREATE TABLE IF NOT EXISTS post_categories (
         post_id INTEGER NOT NULL,
         category TEXT NOT NULL,
@@ -147,6 +348,22 @@ func InitDB() {
 
     CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(sender_id, recipient_id, created_at);
     CREATE INDEX IF NOT EXISTS idx_posts_user ON posts(user_id);
+    CREATE INDEX IF NOT EXISTS idx_post_attachments_post ON post_attachments(post_id, position);
+    CREATE INDEX IF NOT EXISTS idx_poll_options_post ON poll_options(post_id, position);
+    CREATE INDEX IF NOT EXISTS idx_poll_choices_option ON poll_choices(option_id);
+    CREATE INDEX IF NOT EXISTS idx_link_previews_status ON link_previews(status);
+    CREATE INDEX IF NOT EXISTS idx_post_categories_category ON post_categories(category);
+    CREATE INDEX IF NOT EXISTS idx_community_subscriptions_user ON community_subscriptions(user_id);
+    CREATE INDEX IF NOT EXISTS idx_follows_followee ON follows(followee_id);
+    CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, is_read, created_at);
+    CREATE INDEX IF NOT EXISTS idx_saved_items_user ON saved_items(user_id, created_at);
+    CREATE INDEX IF NOT EXISTS idx_comments_post ON comments(post_id, parent_id);
+    CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id);
+    CREATE INDEX IF NOT EXISTS idx_comment_likes_comment ON comment_likes(comment_id, is_like);
+    CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment ON comment_revisions(comment_id, replaced_at);
+    CREATE INDEX IF NOT EXISTS idx_mentions_post ON mentions(post_id);
+    CREATE INDEX IF NOT EXISTS idx_mentions_comment ON mentions(comment_id);
+    CREATE INDEX IF NOT EXISTS idx_mentions_message ON mentions(message_id);
     CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
     CREATE INDEX IF NOT EXISTS idx_user_status ON user_status(user_id);
     `
@@ -163,5 +380,110 @@ func InitDB() {
 if err != nil {
     log.Printf("Error initializing user status: %v", err)
 }
-  
+
+	// The counter columns start at zero on an existing database, so fill them
+	// in once when they are first added
+	countersExisted, err := columnExists("posts", "comment_count")
+	if err != nil {
+		log.Fatalf("Error inspecting posts: %v", err)
+	}
+	karmaExisted, err := columnExists("users", "post_karma")
+	if err != nil {
+		log.Fatalf("Error inspecting users: %v", err)
+	}
+	runMigrations()
+	if _, err := db.Exec(counterTriggers); err != nil {
+		log.Fatal("Error creating counter triggers:", err)
+	}
+	if _, err := db.Exec(karmaTriggers); err != nil {
+		log.Fatal("Error creating karma triggers:", err)
+	}
+	if !countersExisted {
+		if _, _, err := RepairCounters(); err != nil {
+			log.Printf("Error filling in counters: %v", err)
+		}
+	}
+	if !karmaExisted {
+		if _, err := RepairKarma(); err != nil {
+			log.Printf("Error filling in karma: %v", err)
+		}
+	}
+	if err := seedDefaultCommunities(); err != nil {
+		log.Printf("Error seeding communities: %v", err)
+	}
+	if err := migrateLegacyImages(); err != nil {
+		log.Printf("Error migrating post images: %v", err)
+	}
+	initSearch()
+	backfillContentHTML()
+}
+
+// columnMigrations lists columns added to existing tables after the original
+// schema. SQLite has no ADD COLUMN IF NOT EXISTS, so runMigrations only adds
+// the ones that are missing.
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"posts", "content_html", "TEXT NOT NULL DEFAULT ''"},
+	{"comments", "content_html", "TEXT NOT NULL DEFAULT ''"},
+	{"posts", "thumbnail_path", "TEXT NOT NULL DEFAULT ''"},
+	{"posts", "status", "TEXT NOT NULL DEFAULT 'published'"},
+	{"posts", "publish_at", "DATETIME"},
+	{"posts", "post_type", "TEXT NOT NULL DEFAULT 'text'"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"follows", "notify", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"posts", "is_nsfw", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"posts", "is_spoiler", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "nsfw_preference", "TEXT NOT NULL DEFAULT 'blur'"},
+	{"posts", "locked", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"posts", "archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"communities", "default_comment_sort", "TEXT NOT NULL DEFAULT ''"},
+	{"comments", "edited_at", "DATETIME"},
+	{"comments", "deleted_at", "DATETIME"},
+	{"users", "mention_notifications", "BOOLEAN NOT NULL DEFAULT TRUE"},
+	{"notifications", "message_id", "INTEGER REFERENCES messages(id) ON DELETE CASCADE"},
+	{"posts", "like_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "dislike_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "score", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "comment_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "like_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "dislike_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "score", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "comment_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "post_karma", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "comment_karma", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func runMigrations() {
+	for _, m := range columnMigrations {
+		exists, err := columnExists(m.table, m.column)
+		if err != nil {
+			log.Fatalf("Error inspecting %s: %v", m.table, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + m.table + " ADD COLUMN " + m.column + " " + m.definition); err != nil {
+			log.Fatalf("Error adding %s.%s: %v", m.table, m.column, err)
+		}
+	}
+}
+
+// columnExists reports whether table already has the named column
+func columnExists(table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post lifecycle. Only published posts appear in feeds and search; drafts and
+// scheduled posts are visible to their author through /api/drafts.
+const (
+	postStatusDraft     = "draft"
+	postStatusScheduled = "scheduled"
+	postStatusPublished = "published"
+)
+
+// parsePublishAt accepts RFC 3339 timestamps as well as the
+// "2006-01-02T15:04" format sent by datetime-local inputs, which is read in
+// the server's local time.
+func parsePublishAt(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+}
+
+// normalizeCategories trims, lowercases and de-duplicates category names
+func normalizeCategories(categories []string) []string {
+	seen := make(map[string]struct{})
+	var unique []string
+	for _, c := range categories {
+		c = strings.TrimSpace(strings.ToLower(c))
+		if _, ok := seen[c]; c == "" || ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		unique = append(unique, c)
+	}
+	return unique
+}
+
+// replaceCategories swaps the categories of a post for the given set
+func replaceCategories(tx *sql.Tx, postID int64, categories []string) error {
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+	for _, category := range normalizeCategories(categories) {
+		if _, err := tx.Exec("INSERT INTO post_categories (post_id, category) VALUES (?, ?)", postID, category); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DraftsHandler lists the current user's drafts and scheduled posts on GET and
+// discards one with DELETE /api/drafts?id=N.
+func DraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to manage drafts", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+			SELECT p.id, p.title, p.content, p.content_html, p.post_type,
+				COALESCE(GROUP_CONCAT(DISTINCT pc.category), '') AS categories,
+				u.username, p.created_at, p.status, p.publish_at
+			FROM posts p
+			JOIN users u ON p.user_id = u.id
+			LEFT JOIN post_categories pc ON p.id = pc.post_id
+			WHERE p.user_id = ? AND p.status != 'published'
+			GROUP BY p.id
+			ORDER BY p.created_at DESC`, userID)
+		if err != nil {
+			log.Printf("Error fetching drafts: %v", err)
+			respondWithError(w, "Error fetching drafts", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		drafts := []Post{}
+		for rows.Next() {
+			var post Post
+			var publishAt sql.NullTime
+			if err := rows.Scan(
+				&post.ID,
+				&post.Title,
+				&post.Content,
+				&post.ContentHTML,
+				&post.PostType,
+				&post.Categories,
+				&post.Username,
+				&post.CreatedAt,
+				&post.Status,
+				&publishAt,
+			); err != nil {
+				log.Printf("Error scanning draft: %v", err)
+				continue
+			}
+			if publishAt.Valid {
+				post.PublishAt = &publishAt.Time
+			}
+			post.CreatedAtHuman = TimeAgo(post.CreatedAt)
+			drafts = append(drafts, post)
+		}
+
+		if err := loadPostDetails(drafts, userID); err != nil {
+			log.Printf("Error fetching post details: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"drafts":  drafts,
+		})
+
+	case http.MethodDelete:
+		postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || postID <= 0 {
+			respondWithError(w, "Invalid draft ID", http.StatusBadRequest)
+			return
+		}
+		result, err := db.Exec("DELETE FROM posts WHERE id = ? AND user_id = ? AND status != 'published'", postID, userID)
+		if err != nil {
+			log.Printf("Error deleting draft: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			respondWithError(w, "Draft not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AutosaveDraftHandler stores the composer's current state. Without an id it
+// creates a new draft and returns its id; with one it overwrites that draft,
+// which must belong to the current user and not be published yet.
+func AutosaveDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to save drafts", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		ID         int64    `json:"id"`
+		Title      string   `json:"title"`
+		Content    string   `json:"content"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	request.Title = strings.TrimSpace(request.Title)
+	request.Content = strings.TrimSpace(request.Content)
+	if err := checkCommunities(request.Categories); errors.Is(err, errUnknownCommunity) {
+		respondWithError(w, "Unknown community", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error checking communities: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	contentHTML, _ := renderWithMentions(request.Content)
+	postID := request.ID
+	if postID == 0 {
+		result, err := tx.Exec(
+			"INSERT INTO posts (user_id, title, content, content_html, status, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			userID, request.Title, request.Content, contentHTML, postStatusDraft, time.Now())
+		if err != nil {
+			log.Printf("Error creating draft: %v", err)
+			respondWithError(w, "Error saving draft", http.StatusInternalServerError)
+			return
+		}
+		postID, _ = result.LastInsertId()
+	} else {
+		result, err := tx.Exec(`
+			UPDATE posts SET title = ?, content = ?, content_html = ?
+			WHERE id = ? AND user_id = ? AND status != 'published'`,
+			request.Title, request.Content, contentHTML, postID, userID)
+		if err != nil {
+			log.Printf("Error updating draft: %v", err)
+			respondWithError(w, "Error saving draft", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			respondWithError(w, "Draft not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if err := replaceCategories(tx, postID, request.Categories); err != nil {
+		log.Printf("Error saving draft categories: %v", err)
+		respondWithError(w, "Error saving draft", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"id":       postID,
+		"saved_at": time.Now(),
+	})
+}
+
+// PublishDraftHandler publishes a draft immediately, or schedules it when
+// publish_at is in the future. The draft must be complete: a title, content
+// for text posts, and at least one category.
+func PublishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to publish", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		ID        int64  `json:"id"`
+		PublishAt string `json:"publish_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID <= 0 {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	status, publishAt := postStatusPublished, now
+	if request.PublishAt != "" {
+		t, err := parsePublishAt(request.PublishAt)
+		if err != nil {
+			respondWithError(w, "Invalid publish time", http.StatusBadRequest)
+			return
+		}
+		if t.After(now) {
+			status, publishAt = postStatusScheduled, t
+		}
+	}
+
+	var title, content, postType string
+	var categoryCount int
+	err := db.QueryRow(`
+		SELECT p.title, p.content, p.post_type, (SELECT COUNT(*) FROM post_categories WHERE post_id = p.id)
+		FROM posts p
+		WHERE p.id = ? AND p.user_id = ? AND p.status != 'published'`,
+		request.ID, userID).Scan(&title, &content, &postType, &categoryCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Draft not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading draft: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Polls and links don't need a body
+	if title == "" || (content == "" && postType == postTypeText) || categoryCount == 0 {
+		respondWithError(w, "Title, content, and at least one category are required", http.StatusBadRequest)
+		return
+	}
+
+	// A published post's created_at is its publication time so it lands at
+	// the top of the feeds rather than where the draft was started.
+	if status == postStatusPublished {
+		_, err = db.Exec("UPDATE posts SET status = ?, publish_at = NULL, created_at = ? WHERE id = ?", status, now, request.ID)
+	} else {
+		_, err = db.Exec("UPDATE posts SET status = ?, publish_at = ? WHERE id = ?", status, publishAt, request.ID)
+	}
+	if err != nil {
+		log.Printf("Error publishing draft: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if status == postStatusPublished {
+		onPostPublished(request.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"id":         request.ID,
+		"status":     status,
+		"publish_at": publishAt,
+	})
+}
+
+// publishDuePosts publishes every scheduled post whose time has come and
+// returns how many it published.
+func publishDuePosts(now time.Time) (int64, error) {
+	rows, err := db.Query(`
+		UPDATE posts SET status = 'published', created_at = publish_at, publish_at = NULL
+		WHERE status = 'scheduled' AND julianday(publish_at) <= julianday(?)
+		RETURNING id`, now)
+	if err != nil {
+		return 0, err
+	}
+	var published []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			published = append(published, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range published {
+		onPostPublished(id)
+	}
+	return int64(len(published)), nil
+}
+
+// StartScheduler publishes scheduled posts as they fall due. It blocks, so
+// run it in its own goroutine like StartChatManager.
+func StartScheduler() {
+	ticker := time.NewTicker(time.Duration(SchedulerIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		if n, err := publishDuePosts(time.Now()); err != nil {
+			log.Printf("Error publishing scheduled posts: %v", err)
+		} else if n > 0 {
+			log.Printf("Published %d scheduled post(s)", n)
+		}
+		if n, err := archiveOldPosts(time.Now()); err != nil {
+			log.Printf("Error archiving old posts: %v", err)
+		} else if n > 0 {
+			log.Printf("Archived %d post(s)", n)
+		}
+		<-ticker.C
+	}
+}
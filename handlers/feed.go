@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// feedSorts maps the sort query parameter to an ORDER BY clause. "hot"
+// divides the score by the square of the post's age in hours so new posts
+// with a few votes outrank old ones with many.
+var feedSorts = map[string]string{
+	"new": "p.created_at DESC, p.id DESC",
+	"top": "score DESC, p.created_at DESC, p.id DESC",
+	"hot": `(score + 1) / (((julianday('now') - julianday(p.created_at)) * 24 + 2) *
+		((julianday('now') - julianday(p.created_at)) * 24 + 2)) DESC, p.id DESC`,
+}
+
+// parseFeedSort reads the sort parameter, defaulting to def. ok is false for
+// an unknown sort.
+func parseFeedSort(r *http.Request, def string) (sort string, ok bool) {
+	sort = r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = def
+	}
+	_, ok = feedSorts[sort]
+	return sort, ok
+}
+
+// feedQuery describes one page of published posts. Each condition in where
+// is ANDed with the others and refers to the post as p; args holds their
+// placeholders in order. Posts hidden by hiddenBy, a user ID, are left out.
+// Posts pinned in any of pinnedIn, community names or siteWidePin, come
+// first and are marked Pinned. Posts come without their comments, which are
+// fetched a page at a time from /api/comments, and CommentCount counts them.
+type feedQuery struct {
+	where    []string
+	args     []interface{}
+	sort     string
+	limit    int
+	offset   int
+	hiddenBy string
+	pinnedIn []string
+}
+
+// and adds a condition and its arguments
+func (q *feedQuery) and(condition string, args ...interface{}) {
+	q.where = append(q.where, condition)
+	q.args = append(q.args, args...)
+}
+
+// queryFeed runs q and returns the page of posts with their details loaded
+// for viewerID, and whether another page follows.
+func queryFeed(q feedQuery, viewerID string) ([]Post, bool, error) {
+	pinned := "0"
+	var args []interface{}
+	if len(q.pinnedIn) > 0 {
+		pinned = "EXISTS(SELECT 1 FROM pinned_posts WHERE post_id = p.id AND category IN (?" +
+			strings.Repeat(",?", len(q.pinnedIn)-1) + "))"
+		for _, category := range q.pinnedIn {
+			args = append(args, category)
+		}
+	}
+
+	where := append([]string{"p.status = 'published'"}, q.where...)
+	args = append(args, q.args...)
+	if q.hiddenBy != "" {
+		where = append(where, notHiddenCondition)
+		args = append(args, q.hiddenBy)
+	}
+	mode, err := nsfwMode(viewerID)
+	if err != nil {
+		return nil, false, err
+	}
+	if mode == nsfwHide {
+		where = append(where, "p.is_nsfw = 0")
+	}
+	args = append(args, q.limit+1, q.offset)
+
+	rows, err := db.Query(`
+		SELECT p.id, p.title, p.content, p.content_html, p.post_type,
+			COALESCE((SELECT GROUP_CONCAT(category) FROM post_categories WHERE post_id = p.id), '') AS categories,
+			u.username, u.post_karma + u.comment_karma, p.created_at,
+			p.like_count, p.dislike_count, p.comment_count, p.score,
+			`+pinned+` AS pinned
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY pinned DESC, `+feedSorts[q.sort]+`
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		var createdAt time.Time
+		var score int
+		if err := rows.Scan(
+			&post.ID,
+			&post.Title,
+			&post.Content,
+			&post.ContentHTML,
+			&post.PostType,
+			&post.Categories,
+			&post.Username,
+			&post.AuthorKarma,
+			&createdAt,
+			&post.LikeCount,
+			&post.DislikeCount,
+			&post.CommentCount,
+			&score,
+			&post.Pinned,
+		); err != nil {
+			return nil, false, err
+		}
+		post.CreatedAt = createdAt
+		post.CreatedAtHuman = TimeAgo(createdAt)
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	rows.Close()
+
+	hasMore := len(posts) > q.limit
+	if hasMore {
+		posts = posts[:q.limit]
+	}
+
+	if err := loadPostDetails(posts, viewerID); err != nil {
+		return nil, false, err
+	}
+	return posts, hasMore, nil
+}
+
+// hasFeedSources reports whether the user subscribes to any community or
+// follows anyone, i.e. whether a personal feed can have anything in it.
+func hasFeedSources(userID string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM community_subscriptions WHERE user_id = ?)
+			OR EXISTS(SELECT 1 FROM follows WHERE follower_id = ?)`, userID, userID).Scan(&exists)
+	return exists, err
+}
+
+// personalFeedCondition limits a feed to posts in the user's subscribed
+// communities, by authors they follow, or by themselves.
+const personalFeedCondition = `(
+	EXISTS (
+		SELECT 1 FROM post_categories pc
+		JOIN communities c ON c.name = pc.category
+		JOIN community_subscriptions s ON s.community_id = c.id
+		WHERE pc.post_id = p.id AND s.user_id = ?
+	)
+	OR p.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)
+	OR p.user_id = ?
+)`
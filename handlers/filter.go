@@ -3,37 +3,15 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var validCategories = []string{
-	"technology",
-	"general",
-	"lifestyle",
-	"entertainment",
-	"gaming",
-	"food",
-	"business",
-	"religion",
-	"health",
-	"music",
-	"sports",
-	"beauty",
-	"jobs",
-}
-
-func isValidCategory(category string) bool {
-	for _, validCategory := range validCategories {
-		if validCategory == category {
-			return true
-		}
-	}
-	return false
-}
-
 func FilterHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if the user is logged in
 	var userID string
@@ -61,144 +39,141 @@ func FilterHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get the category from the query parameters
-	category := r.URL.Query().Get("category")
-	// if category == "" {
-	// 	RenderError(w, r, "Error: Category parameter is missing or incomplete.", http.StatusBadRequest)
-	// 	return
-	// }
-
-	// Validate the category
-	if category != "all" && category != "" && !isValidCategory(category) {
-		RenderError(w, r, "Invalid category selected", http.StatusBadRequest)
-		return
-	}
-
-	// Query to fetch posts based on the selected category
-	query := `
-		SELECT p.id, p.title, p.content, p.image_path, GROUP_CONCAT(DISTINCT pc.category) as categories, 
-		u.username, p.created_at, 
-		COALESCE(l.like_count, 0) AS like_count,
-		COALESCE(l.dislike_count, 0) AS dislike_count
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		LEFT JOIN post_categories pc ON p.id = pc.post_id
-		LEFT JOIN (
-			SELECT post_id, 
-			COUNT(CASE WHEN is_like = 1 THEN 1 END) AS like_count,
-			COUNT(CASE WHEN is_like = 0 THEN 1 END) AS dislike_count
-			FROM likes
-			GROUP BY post_id
-		) l ON p.id = l.post_id
-	`
-	if category != "all" && category != "" {
-		query += " WHERE pc.category = ?"
-	}
-	query += " GROUP BY p.id, p.title, p.content, u.username, p.created_at ORDER BY p.created_at DESC"
-
-	// Execute the query
-	var rows *sql.Rows
-	if category != "all" && category != "" {
-		rows, err = db.Query(query, category)
-	} else {
-		rows, err = db.Query(query)
-	}
+	page, _, _ := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+	query, categories, err := parseFilterQuery(r, userID)
 	if err != nil {
-		log.Printf("Error fetching posts: %v", err)
-		RenderError(w, r, "Error fetching posts", http.StatusInternalServerError)
+		RenderError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-
-	// Parse the rows into a slice of Post structs
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		var createdAt time.Time
-		var categories sql.NullString
-		err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Content,
-			&post.ImagePath,
-			&categories,
-			&post.Username,
-			&createdAt,
-			&post.LikeCount,
-			&post.DislikeCount,
-		)
+	for _, category := range categories {
+		exists, err := communityExists(category)
 		if err != nil {
-			log.Printf("Error scanning post: %v", err)
-			RenderError(w, r, "Error scanning posts", http.StatusInternalServerError)
+			log.Printf("Error checking community: %v", err)
+			RenderError(w, r, "Database Error", http.StatusInternalServerError)
 			return
-		}
-		if categories.Valid {
-			post.Categories = categories.String
-		} else {
-			post.Categories = ""
-		}
-
-		// Set the CreatedAt field and the human-readable time
-		post.CreatedAt = createdAt
-		post.CreatedAtHuman = TimeAgo(createdAt)
-
-		commentQuery := `
-			SELECT c.id, c.content, u.username, c.created_at, 
-       COALESCE(clike.like_count, 0) AS like_count,
-       COALESCE(cdislike.dislike_count, 0) AS dislike_count
-FROM comments c
-JOIN users u ON c.user_id = u.id
-LEFT JOIN (
-    SELECT comment_id, COUNT(CASE WHEN is_like = 1 THEN 1 END) AS like_count
-    FROM comment_likes
-    GROUP BY comment_id
-) clike ON c.id = clike.comment_id
-LEFT JOIN (
-    SELECT comment_id, COUNT(CASE WHEN is_like = 0 THEN 1 END) AS dislike_count
-    FROM comment_likes
-    GROUP BY comment_id
-) cdislike ON c.id = cdislike.comment_id
-WHERE c.post_id = ?
-ORDER BY c.created_at DESC
-
-		`
-		commentRows, err := db.Query(commentQuery, post.ID)
-		if err != nil {
-			log.Printf("Error fetching comments: %v", err)
-			RenderError(w, r, "Error fetching comments", http.StatusInternalServerError)
+		} else if !exists {
+			RenderError(w, r, "Invalid category selected", http.StatusBadRequest)
 			return
 		}
-		defer commentRows.Close()
-
-		var comments []Comment
-		for commentRows.Next() {
-			var comment Comment
-			var createdAt time.Time
-			err := commentRows.Scan(&comment.ID, &comment.Content, &comment.Username, &createdAt, &comment.LikeCount, &comment.DislikeCount)
-			if err != nil {
-				log.Printf("Error scanning comment: %v", err)
-				RenderError(w, r, "Error scanning comments", http.StatusInternalServerError)
-				return
-			}
-			comments = append(comments, comment)
-		}
+	}
 
-		// Set the CreatedAt field and the human-readable time
-		post.CreatedAt = createdAt
-		post.CreatedAtHuman = TimeAgo(createdAt)
+	posts, hasMore, err := queryFeed(query, userID)
+	if err != nil {
+		log.Printf("Error fetching posts: %v", err)
+		RenderError(w, r, "Error fetching posts", http.StatusInternalServerError)
+		return
+	}
 
-		post.Comments = comments
-		posts = append(posts, post)
+	selected := "all"
+	if len(categories) > 0 {
+		selected = strings.Join(categories, ",")
 	}
 
 	data := map[string]interface{}{
 		"Posts":            posts,
 		"IsLoggedIn":       isLoggedIn,
-		"SelectedCategory": category,
+		"SelectedCategory": selected,
+		"sort":             query.sort,
+		"page":             page,
+		"limit":            query.limit,
+		"has_more":         hasMore,
 	}
-	// fmt.Println(data)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		fmt.Printf("Error encoding JSON: %v", err)
 	}
 }
+
+// parseFilterQuery turns the filter parameters into a feed query:
+//
+//	category    repeatable, or comma separated; "all" means no filter
+//	match       any (default) or all of the categories
+//	author      username or nickname
+//	from, to    creation date range, YYYY-MM-DD or RFC 3339
+//	min_score   likes minus dislikes
+//	has_image   only posts with image attachments
+//	liked       only posts the viewer liked
+//	commented   only posts the viewer commented on
+//	sort, page, limit as on the home feed
+//
+// Every condition is fixed SQL with placeholders; request values only ever
+// travel as arguments. Posts pinned site-wide or in one of the categories
+// come first. It also returns the normalized categories so the caller can
+// check they exist.
+func parseFilterQuery(r *http.Request, userID string) (feedQuery, []string, error) {
+	params := r.URL.Query()
+	_, limit, offset := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+	sort, ok := parseFeedSort(r, "new")
+	if !ok {
+		return feedQuery{}, nil, errors.New("Invalid sort order")
+	}
+	query := feedQuery{sort: sort, limit: limit, offset: offset, hiddenBy: userID}
+
+	var raw []string
+	for _, value := range params["category"] {
+		raw = append(raw, strings.Split(value, ",")...)
+	}
+	var categories []string
+	for _, category := range normalizeCategories(raw) {
+		if category != "all" {
+			categories = append(categories, category)
+		}
+	}
+	query.pinnedIn = append([]string{siteWidePin}, categories...)
+	if len(categories) > 0 {
+		in := "(?" + strings.Repeat(",?", len(categories)-1) + ")"
+		args := make([]interface{}, len(categories))
+		for i, category := range categories {
+			args[i] = category
+		}
+		switch params.Get("match") {
+		case "", "any":
+			query.and("EXISTS (SELECT 1 FROM post_categories WHERE post_id = p.id AND category IN "+in+")", args...)
+		case "all":
+			query.and("(SELECT COUNT(DISTINCT category) FROM post_categories WHERE post_id = p.id AND category IN "+in+") = ?",
+				append(args, len(categories))...)
+		default:
+			return feedQuery{}, nil, errors.New("match must be any or all")
+		}
+	}
+
+	if author := strings.TrimSpace(params.Get("author")); author != "" {
+		query.and("p.user_id IN (SELECT id FROM users WHERE username = ? OR nickname = ?)", author, author)
+	}
+	if from := params.Get("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			return feedQuery{}, nil, errors.New("Invalid from date")
+		}
+		query.and("julianday(p.created_at) >= julianday(?)", t)
+	}
+	if to := params.Get("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			return feedQuery{}, nil, errors.New("Invalid to date")
+		}
+		query.and("julianday(p.created_at) <= julianday(?)", t)
+	}
+	if value := params.Get("min_score"); value != "" {
+		minScore, err := strconv.Atoi(value)
+		if err != nil {
+			return feedQuery{}, nil, errors.New("Invalid minimum score")
+		}
+		query.and("p.score >= ?", minScore)
+	}
+	if formBool(params.Get("has_image")) {
+		query.and("EXISTS (SELECT 1 FROM post_attachments WHERE post_id = p.id)")
+	}
+
+	liked, commented := formBool(params.Get("liked")), formBool(params.Get("commented"))
+	if (liked || commented) && userID == "" {
+		return feedQuery{}, nil, errors.New("Log in to filter by your own activity")
+	}
+	if liked {
+		query.and("EXISTS (SELECT 1 FROM likes WHERE post_id = p.id AND user_id = ? AND is_like = 1)", userID)
+	}
+	if commented {
+		query.and("EXISTS (SELECT 1 FROM comments WHERE post_id = p.id AND user_id = ?)", userID)
+	}
+	return query, categories, nil
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// FollowUser is an entry in a follower or following list
+type FollowUser struct {
+	Username   string    `json:"username"`
+	Nickname   string    `json:"nickname"`
+	AvatarURL  string    `json:"avatar_url"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// userIDFromPath resolves the {username} path value, writing a 404 when no
+// such user exists.
+func userIDFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var id string
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", r.PathValue("username")).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "User not found", http.StatusNotFound)
+		return "", false
+	} else if err != nil {
+		log.Printf("Error looking up user: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return "", false
+	}
+	return id, true
+}
+
+// followCounts returns how many users follow userID and how many it follows
+func followCounts(userID string) (followers, following int, err error) {
+	err = db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM follows WHERE followee_id = ?),
+			(SELECT COUNT(*) FROM follows WHERE follower_id = ?)`, userID, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+// UserProfileHandler returns another user's public profile: their names,
+// avatar, karma, follow counts and whether the viewer follows or blocked
+// them.
+func UserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := GetUserIdFromSession(w, r)
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var username, nickname, avatarURL string
+	var postKarma, commentKarma int
+	var isFollowing, isBlocked bool
+	err := db.QueryRow(`
+		SELECT username, COALESCE(nickname, ''), COALESCE(avatar_url, ''), post_karma, comment_karma,
+			EXISTS(SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = users.id),
+			EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = users.id)
+		FROM users WHERE id = ?`, viewerID, viewerID, userID).Scan(&username, &nickname, &avatarURL, &postKarma, &commentKarma, &isFollowing, &isBlocked)
+	if err != nil {
+		log.Printf("Error fetching user profile: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	followers, following, err := followCounts(userID)
+	if err != nil {
+		log.Printf("Error counting follows: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"username":        username,
+		"nickname":        nickname,
+		"avatar_url":      avatarURL,
+		"post_karma":      postKarma,
+		"comment_karma":   commentKarma,
+		"karma":           postKarma + commentKarma,
+		"follower_count":  followers,
+		"following_count": following,
+		"is_following":    isFollowing,
+		"is_blocked":      isBlocked,
+		"is_self":         viewerID == userID,
+	})
+}
+
+// FollowHandler follows a user on POST and unfollows them on DELETE. A
+// follow may ask to be notified of the user's new posts with
+// {"notify": true}; following again updates that choice.
+func FollowHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := GetUserIdFromSession(w, r)
+	if viewerID == "" {
+		respondWithError(w, "Please log in to follow users", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if userID == viewerID {
+		respondWithError(w, "You cannot follow yourself", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		var request struct {
+			Notify bool `json:"notify"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				respondWithError(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+		}
+		_, err = db.Exec(`
+			INSERT INTO follows (follower_id, followee_id, notify, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (follower_id, followee_id) DO UPDATE SET notify = excluded.notify`,
+			viewerID, userID, request.Notify, time.Now())
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", viewerID, userID)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating follow: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	followers, _, err := followCounts(userID)
+	if err != nil {
+		log.Printf("Error counting follows: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"following":      r.Method == http.MethodPost,
+		"follower_count": followers,
+	})
+}
+
+// FollowersHandler lists the users following {username}, newest first
+func FollowersHandler(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, `
+		SELECT u.username, COALESCE(u.nickname, ''), COALESCE(u.avatar_url, ''), f.created_at
+		FROM follows f JOIN users u ON u.id = f.follower_id
+		WHERE f.followee_id = ?
+		ORDER BY f.created_at DESC
+		LIMIT ? OFFSET ?`, "SELECT COUNT(*) FROM follows WHERE followee_id = ?")
+}
+
+// FollowingHandler lists the users {username} follows, newest first
+func FollowingHandler(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, `
+		SELECT u.username, COALESCE(u.nickname, ''), COALESCE(u.avatar_url, ''), f.created_at
+		FROM follows f JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = ?
+		ORDER BY f.created_at DESC
+		LIMIT ? OFFSET ?`, "SELECT COUNT(*) FROM follows WHERE follower_id = ?")
+}
+
+func listFollows(w http.ResponseWriter, r *http.Request, listQuery, countQuery string) {
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	page, limit, offset := parsePagination(r, 50, 200)
+
+	var total int
+	if err := db.QueryRow(countQuery, userID).Scan(&total); err != nil {
+		log.Printf("Error counting follows: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(listQuery, userID, limit, offset)
+	if err != nil {
+		log.Printf("Error listing follows: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []FollowUser{}
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.Username, &u.Nickname, &u.AvatarURL, &u.FollowedAt); err != nil {
+			log.Printf("Error scanning follow: %v", err)
+			continue
+		}
+		users = append(users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"users":    users,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+		"has_more": offset+len(users) < total,
+	})
+}
+
+// FollowingFeedHandler lists published posts by the users the viewer
+// follows, with the same sorting and pagination as the home feed.
+func FollowingFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to see your following feed", http.StatusUnauthorized)
+		return
+	}
+
+	page, limit, offset := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+	sort, ok := parseFeedSort(r, "new")
+	if !ok {
+		respondWithError(w, "Invalid sort order", http.StatusBadRequest)
+		return
+	}
+
+	query := feedQuery{sort: sort, limit: limit, offset: offset, hiddenBy: userID}
+	query.and("p.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)", userID)
+	posts, hasMore, err := queryFeed(query, userID)
+	if err != nil {
+		log.Printf("Error fetching following feed: %v", err)
+		respondWithError(w, "Error fetching posts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"posts":    posts,
+		"sort":     sort,
+		"page":     page,
+		"limit":    limit,
+		"has_more": hasMore,
+	})
+}
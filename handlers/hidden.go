@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notHiddenCondition leaves out the posts a user has hidden. It refers to
+// the post as p and takes the user ID, which may be empty for guests.
+const notHiddenCondition = "p.id NOT IN (SELECT post_id FROM hidden_posts WHERE user_id = ?)"
+
+// HiddenPost is an entry in the hidden posts list
+type HiddenPost struct {
+	HiddenAt time.Time `json:"hidden_at"`
+	Post     Post      `json:"post"`
+}
+
+// HidePostHandler hides published post {id} from the user's feeds and
+// search on POST and shows it again on DELETE.
+func HidePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to hide posts", http.StatusUnauthorized)
+		return
+	}
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || postID <= 0 {
+		respondWithError(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND status = 'published')", postID).Scan(&exists); err != nil {
+			log.Printf("Error checking post: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		} else if !exists {
+			respondWithError(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		_, err = db.Exec("INSERT OR IGNORE INTO hidden_posts (user_id, post_id, created_at) VALUES (?, ?, ?)",
+			userID, postID, time.Now())
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM hidden_posts WHERE user_id = ? AND post_id = ?", userID, postID)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating hidden post: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"hidden":  r.Method == http.MethodPost,
+	})
+}
+
+// HiddenPostsHandler lists the posts the user has hidden, most recently
+// hidden first, so they can be unhidden.
+func HiddenPostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to see hidden posts", http.StatusUnauthorized)
+		return
+	}
+	page, limit, offset := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+
+	rows, err := db.Query(`
+		SELECT h.post_id, h.created_at
+		FROM hidden_posts h
+		JOIN posts p ON p.id = h.post_id
+		WHERE h.user_id = ? AND p.status = 'published'
+		ORDER BY h.created_at DESC, h.post_id DESC
+		LIMIT ? OFFSET ?`, userID, limit+1, offset)
+	if err != nil {
+		log.Printf("Error fetching hidden posts: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	var hidden []HiddenPost
+	for rows.Next() {
+		var id int
+		var entry HiddenPost
+		if err := rows.Scan(&id, &entry.HiddenAt); err != nil {
+			log.Printf("Error scanning hidden post: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		entry.Post.ID = id
+		ids = append(ids, id)
+		hidden = append(hidden, entry)
+	}
+	rows.Close()
+	hasMore := len(hidden) > limit
+	if hasMore {
+		hidden, ids = hidden[:limit], ids[:limit]
+	}
+
+	posts, err := postsByID(ids, userID)
+	if err != nil {
+		log.Printf("Error fetching hidden posts: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	entries := []HiddenPost{}
+	for _, entry := range hidden {
+		if post, ok := posts[entry.Post.ID]; ok {
+			entry.Post = post
+			entries = append(entries, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"hidden":   entries,
+		"page":     page,
+		"limit":    limit,
+		"has_more": hasMore,
+	})
+}
+
+// postsByID loads the given published posts as a feed would show them,
+// keyed by ID, for lists kept in an order of their own.
+func postsByID(ids []interface{}, viewerID string) (map[int]Post, error) {
+	byID := make(map[int]Post)
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	query := feedQuery{sort: "new", limit: len(ids)}
+	query.and("p.id IN (?"+strings.Repeat(",?", len(ids)-1)+")", ids...)
+	posts, _, err := queryFeed(query, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	return byID, nil
+}
@@ -1,97 +1,78 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
-	"time"
 )
 
+// HomeHandler serves the home feed. Logged-in users get their personal feed:
+// posts from subscribed communities and followed authors. Users with nothing
+// to follow yet, or whose feed is empty, get popular posts instead, and
+// anonymous visitors get the global feed. The "feed" field of the response
+// says which one was served. Site-wide pinned posts come first in all three.
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-    userID := GetUserIdFromSession(w, r)
+	userID := GetUserIdFromSession(w, r)
 
-    // Query to fetch all posts along with user info, categories, like counts, and comments
-    rows, err := db.Query(`
-        SELECT p.id, p.title, p.content, p.image_path, GROUP_CONCAT(DISTINCT pc.category) as categories, 
-        u.username, p.created_at, 
-        COALESCE(l.like_count, 0) AS like_count,
-        COALESCE(l.dislike_count, 0) AS dislike_count
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        LEFT JOIN post_categories pc ON p.id = pc.post_id
-        LEFT JOIN (
-            SELECT post_id, 
-            COUNT(CASE WHEN is_like = 1 THEN 1 END) AS like_count,
-            COUNT(CASE WHEN is_like = 0 THEN 1 END) AS dislike_count
-            FROM likes
-            GROUP BY post_id
-        ) l ON p.id = l.post_id
-        GROUP BY p.id, p.title, p.content, u.username, p.created_at
-        ORDER BY p.created_at DESC`)
-    if err != nil {
-        http.Error(w, "Error fetching posts", http.StatusInternalServerError)
-        return
-    }
-    defer rows.Close()
+	page, limit, offset := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+	sort, ok := parseFeedSort(r, "new")
+	if !ok {
+		respondWithError(w, "Invalid sort order", http.StatusBadRequest)
+		return
+	}
 
-    var posts []Post // Initialize as an empty slice
-    for rows.Next() {
-        var post Post
-        var createdAt time.Time
-        var categories sql.NullString
-        err := rows.Scan(
-            &post.ID,
-            &post.Title,
-            &post.Content,
-            &post.ImagePath,
-            &categories,
-            &post.Username,
-            &createdAt,
-            &post.LikeCount,
-            &post.DislikeCount,
-        )
-        if err != nil {
-            http.Error(w, "Error scanning posts", http.StatusInternalServerError)
-            return
-        }
-        if categories.Valid {
-            post.Categories = categories.String
-        } else {
-            post.Categories = ""
-        }
+	feed := "global"
+	pinnedIn := []string{siteWidePin}
+	query := feedQuery{sort: sort, limit: limit, offset: offset, hiddenBy: userID, pinnedIn: pinnedIn}
+	if userID != "" {
+		personal, err := hasFeedSources(userID)
+		if err != nil {
+			log.Printf("Error checking feed sources: %v", err)
+			RenderError(w, r, "Error fetching posts", http.StatusInternalServerError)
+			return
+		}
+		if personal {
+			feed = "personal"
+			query.and("("+personalFeedCondition+" OR p.id IN (SELECT post_id FROM pinned_posts WHERE category = ?))",
+				userID, userID, userID, siteWidePin)
+		} else {
+			feed = "popular"
+			if r.URL.Query().Get("sort") == "" {
+				query.sort = "hot"
+			}
+		}
+	}
 
-        // Set the CreatedAt field and the human-readable time
-        post.CreatedAt = createdAt
-        post.CreatedAtHuman = TimeAgo(createdAt)
+	posts, hasMore, err := queryFeed(query, userID)
+	if err == nil && feed == "personal" && page == 1 && len(posts) == 0 {
+		// Subscribed communities with nothing in them yet
+		feed = "popular"
+		query = feedQuery{sort: "hot", limit: limit, offset: offset, hiddenBy: userID, pinnedIn: pinnedIn}
+		posts, hasMore, err = queryFeed(query, userID)
+	}
+	if err != nil {
+		log.Printf("Error fetching posts: %v", err)
+		RenderError(w, r, "Error fetching posts", http.StatusInternalServerError)
+		return
+	}
 
-        // Fetch comments for this post
-        comments, err := GetCommentsForPost(post.ID)
-        if err != nil {
-            http.Error(w, "Error fetching comments", http.StatusInternalServerError)
-            return
-        }
-        post.Comments = comments
+	// Prepare the JSON response
+	response := map[string]interface{}{
+		"posts":      posts,
+		"isLoggedIn": userID != "",
+		"feed":       feed,
+		"sort":       query.sort,
+		"page":       page,
+		"limit":      limit,
+		"has_more":   hasMore,
+	}
 
-        posts = append(posts, post)
-    }
+	// Set the Content-Type header to application/json
+	w.Header().Set("Content-Type", "application/json")
 
-    // Ensure posts is never null
-    if posts == nil {
-        posts = []Post{} // Initialize as an empty slice
-    }
-
-    // Prepare the JSON response
-    response := map[string]interface{}{
-        "posts":      posts,
-        "isLoggedIn": userID != "",
-    }
-
-    // Set the Content-Type header to application/json
-    w.Header().Set("Content-Type", "application/json")
-
-    // Encode the response as JSON and send it
-    if err := json.NewEncoder(w).Encode(response); err != nil {
-        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-        return
-    }
-}
\ No newline at end of file
+	// Encode the response as JSON and send it
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// karmaTriggers keep users' post_karma and comment_karma, the score of the
+// votes others cast on their posts and comments, in step with likes and
+// comment_likes. Votes on your own posts and comments don't count.
+const karmaTriggers = `
+    CREATE TRIGGER IF NOT EXISTS likes_karma_insert AFTER INSERT ON likes BEGIN
+        UPDATE users SET post_karma = post_karma + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM posts WHERE id = NEW.post_id) AND id != NEW.user_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS likes_karma_delete AFTER DELETE ON likes BEGIN
+        UPDATE users SET post_karma = post_karma - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM posts WHERE id = OLD.post_id) AND id != OLD.user_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS likes_karma_update AFTER UPDATE OF is_like, post_id ON likes BEGIN
+        UPDATE users SET post_karma = post_karma - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM posts WHERE id = OLD.post_id) AND id != OLD.user_id;
+        UPDATE users SET post_karma = post_karma + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM posts WHERE id = NEW.post_id) AND id != NEW.user_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_karma_insert AFTER INSERT ON comment_likes BEGIN
+        UPDATE users SET comment_karma = comment_karma + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM comments WHERE id = NEW.comment_id) AND id != NEW.user_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_karma_delete AFTER DELETE ON comment_likes BEGIN
+        UPDATE users SET comment_karma = comment_karma - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM comments WHERE id = OLD.comment_id) AND id != OLD.user_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS comment_likes_karma_update AFTER UPDATE OF is_like, comment_id ON comment_likes BEGIN
+        UPDATE users SET comment_karma = comment_karma - CASE WHEN OLD.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM comments WHERE id = OLD.comment_id) AND id != OLD.user_id;
+        UPDATE users SET comment_karma = comment_karma + CASE WHEN NEW.is_like = 1 THEN 1 ELSE -1 END
+        WHERE id = (SELECT user_id FROM comments WHERE id = NEW.comment_id) AND id != NEW.user_id;
+    END;
+`
+
+// RepairKarma recomputes every user's karma from the votes on their posts and
+// comments and returns how many users it corrected.
+func RepairKarma() (int64, error) {
+	result, err := db.Exec(`
+		WITH actual AS (
+			SELECT u.id,
+				(SELECT COALESCE(SUM(CASE WHEN l.is_like = 1 THEN 1 ELSE -1 END), 0)
+					FROM likes l JOIN posts p ON p.id = l.post_id
+					WHERE p.user_id = u.id AND l.user_id != u.id) AS post_karma,
+				(SELECT COALESCE(SUM(CASE WHEN cl.is_like = 1 THEN 1 ELSE -1 END), 0)
+					FROM comment_likes cl JOIN comments c ON c.id = cl.comment_id
+					WHERE c.user_id = u.id AND cl.user_id != u.id) AS comment_karma
+			FROM users u
+		)
+		UPDATE users SET post_karma = a.post_karma, comment_karma = a.comment_karma
+		FROM actual a
+		WHERE a.id = users.id AND (users.post_karma != a.post_karma OR users.comment_karma != a.comment_karma)`)
+	if err != nil {
+		return 0, err
+	}
+	users, _ := result.RowsAffected()
+	return users, nil
+}
+
+// userKarma returns the user's post and comment karma
+func userKarma(userID string) (post, comment int, err error) {
+	err = db.QueryRow("SELECT post_karma, comment_karma FROM users WHERE id = ?", userID).Scan(&post, &comment)
+	return post, comment, err
+}
+
+// reputationThresholds maps what needs reputation to the karma it needs
+func reputationThresholds() map[string]int {
+	return map[string]int{
+		"create_community": KarmaToCreateCommunity,
+		"post_links":       KarmaToPostLinks,
+		"send_messages":    KarmaToSendMessages,
+	}
+}
+
+// requireReputation checks that the user has at least required karma to do
+// action, returning a user-facing reason when they don't. Site admins, and
+// everyone when required is 0 or less, pass.
+func requireReputation(userID string, required int, action string) (string, error) {
+	if required <= 0 || isSiteAdmin(userID) {
+		return "", nil
+	}
+	post, comment, err := userKarma(userID)
+	if err != nil {
+		return "", err
+	}
+	if post+comment < required {
+		return fmt.Sprintf("You need %d karma to %s", required, action), nil
+	}
+	return "", nil
+}
+
+// ReputationHandler returns the karma each reputation-gated feature needs
+// and, for a logged-in user, their karma and which of them they can use.
+func ReputationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	thresholds := reputationThresholds()
+	response := map[string]interface{}{
+		"success":    true,
+		"thresholds": thresholds,
+	}
+
+	if userID := GetUserIdFromSession(w, r); userID != "" {
+		post, comment, err := userKarma(userID)
+		if err != nil {
+			log.Printf("Error loading karma: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		admin := isSiteAdmin(userID)
+		allowed := make(map[string]bool, len(thresholds))
+		for name, required := range thresholds {
+			allowed[name] = admin || post+comment >= required
+		}
+		response["post_karma"] = post
+		response["comment_karma"] = comment
+		response["karma"] = post + comment
+		response["allowed"] = allowed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
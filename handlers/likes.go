@@ -3,17 +3,22 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
-	"strings"
 )
 
 type LikeResponse struct {
-	Success      bool `json:"success"`
-	LikeCount    int  `json:"like_count"`
-	DislikeCount int  `json:"dislike_count"`
+	Success      bool   `json:"success"`
+	LikeCount    int    `json:"like_count"`
+	DislikeCount int    `json:"dislike_count"`
+	Score        int    `json:"score"`
+	Vote         string `json:"vote"` // The user's vote now: up, down or none
 }
 
+// LikeHandler sets the user's vote on a post to the vote form value, up,
+// down or none, and returns the post's fresh counts.
 func LikeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -49,69 +54,39 @@ func LikeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	postID := r.FormValue("post_id")
-	isLike, err := strconv.ParseBool(r.FormValue("is_like"))
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
 	if err != nil {
-		http.Error(w, "Invalid like/dislike value", http.StatusBadRequest)
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
 		return
 	}
-
-	// Check if the user has already liked/disliked the post
-	var existingIsLike bool
-	err = db.QueryRow("SELECT is_like FROM likes WHERE post_id = ? AND user_id = ?", postID, userID).Scan(&existingIsLike)
-	if err != nil && err != sql.ErrNoRows {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	vote, ok := parseVote(r)
+	if !ok {
+		http.Error(w, "vote must be up, down or none", http.StatusBadRequest)
 		return
 	}
 
-	// If the user is trying to toggle their like/dislike
-	if err != sql.ErrNoRows {
-		if existingIsLike == isLike {
-			// User is trying to remove their like/dislike
-			_, err = db.Exec("DELETE FROM likes WHERE post_id = ? AND user_id = ?", postID, userID)
-			if err != nil {
-				http.Error(w, "Database error", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			// User is changing their like/dislike
-			_, err = db.Exec("UPDATE likes SET is_like = ? WHERE post_id = ? AND user_id = ?", isLike, postID, userID)
-			if err != nil {
-				http.Error(w, "Database error", http.StatusInternalServerError)
-				return
-			}
-		}
-	} else {
-		// User is adding a new like/dislike
-		_, err = db.Exec("INSERT INTO likes (post_id, user_id, is_like) VALUES (?, ?, ?)", postID, userID, isLike)
-		if err != nil {
-			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-				http.Error(w, "You have already liked/disliked this post", http.StatusBadRequest)
-				return
-			}
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Get the updated like and dislike counts
-	var likeCount, dislikeCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM likes WHERE post_id = ? AND is_like = 1", postID).Scan(&likeCount)
-	if err != nil {
+	// Locked and archived posts keep their votes but take no new ones
+	result, reason, err := setVote(postVotes, postID, userID, vote)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error voting on post %d: %v", postID, err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	err = db.QueryRow("SELECT COUNT(*) FROM likes WHERE post_id = ? AND is_like = 0", postID).Scan(&dislikeCount)
-	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
 		return
 	}
 
 	// Return the updated counts
 	response := LikeResponse{
 		Success:      true,
-		LikeCount:    likeCount,
-		DislikeCount: dislikeCount,
+		LikeCount:    result.LikeCount,
+		DislikeCount: result.DislikeCount,
+		Score:        result.Score,
+		Vote:         result.Vote,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
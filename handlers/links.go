@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const postTypeLink = "link"
+
+const (
+	maxLinkURLLength     = 2048
+	maxLinkPageBytes     = 1 << 20 // only the <head> matters, so a page is cut off here
+	maxPreviewTitle      = 300
+	maxPreviewDesc       = 500
+	maxPreviewRedirects  = 5
+	linkPreviewBatchSize = 20
+)
+
+var (
+	errInvalidLink    = errors.New("link must be a valid http or https URL")
+	errPrivateAddress = errors.New("link points to a private or reserved address")
+)
+
+// LinkPreview is the card shown for a link post. Title, description and the
+// locally stored thumbnail are filled in by the preview worker some time
+// after the post is created.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Domain      string `json:"domain"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImagePath   string `json:"image_path"`
+	Status      string `json:"status"` // pending, ready or failed
+}
+
+// LinkMetadata is what a PreviewFetcher found on a page. Image holds the
+// raw bytes of its OpenGraph image, if it had one we could download.
+type LinkMetadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+	Image       []byte
+}
+
+// PreviewFetcher retrieves the metadata for a link post
+type PreviewFetcher interface {
+	Fetch(ctx context.Context, pageURL string) (*LinkMetadata, error)
+}
+
+// previewFetcher is used by the preview worker; tests may swap it out
+var previewFetcher PreviewFetcher = newHTTPPreviewFetcher(false)
+
+// normalizeLinkURL validates a submitted link and puts it in canonical form:
+// https is assumed when no scheme is given, the scheme and host are
+// lowercased, default ports and fragments are dropped.
+func normalizeLinkURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxLinkURLLength {
+		return "", errInvalidLink
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errInvalidLink
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.User != nil || u.Opaque != "" {
+		return "", errInvalidLink
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" || (!strings.Contains(host, ".") && net.ParseIP(host) == nil) {
+		return "", errInvalidLink
+	}
+
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	u.Fragment, u.RawFragment = "", ""
+	u.ForceQuery = false
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// linkDomain is the host shown on a link card, without a leading "www."
+func linkDomain(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// isPublicAddress reports whether ip is routable on the public internet.
+// Loopback, private, link-local, CGNAT, multicast and unspecified addresses
+// are all refused so a link post cannot make the server probe its own
+// network.
+func isPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64 can reach private IPv4
+}
+
+// httpPreviewFetcher fetches pages over HTTP. The address check runs on
+// every connection the client makes, after DNS resolution and on each
+// redirect, so neither a redirect nor a DNS answer that changes between
+// lookups can reach a private address.
+type httpPreviewFetcher struct {
+	client *http.Client
+}
+
+// newHTTPPreviewFetcher builds the fetcher. allowPrivate lifts the address
+// and port restrictions and exists for tests against a local stub server.
+func newHTTPPreviewFetcher(allowPrivate bool) *httpPreviewFetcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !isPublicAddress(addrPort.Addr()) {
+				return errPrivateAddress
+			}
+			if port := addrPort.Port(); port != 80 && port != 443 {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    5 * time.Second,
+		ResponseHeaderTimeout:  5 * time.Second,
+		MaxResponseHeaderBytes: 64 << 10,
+	}
+	return &httpPreviewFetcher{client: &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(LinkPreviewTimeoutSeconds) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPreviewRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errInvalidLink
+			}
+			return nil
+		},
+	}}
+}
+
+// get downloads at most limit bytes of target. When the body is longer,
+// truncate decides between keeping the first limit bytes and failing.
+func (f *httpPreviewFetcher) get(ctx context.Context, target, accept string, limit int64, truncate bool) ([]byte, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", "ForumLinkPreview/1.0")
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, errors.New("unexpected status " + resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(body)) > limit {
+		if !truncate {
+			return nil, nil, errImageTooLarge
+		}
+		body = body[:limit]
+	}
+	return body, resp, nil
+}
+
+// Fetch reads the page's title, description and OpenGraph image. Pages that
+// aren't HTML, such as a direct link to a PDF, yield empty metadata.
+func (f *httpPreviewFetcher) Fetch(ctx context.Context, pageURL string) (*LinkMetadata, error) {
+	body, resp, err := f.get(ctx, pageURL, "text/html,application/xhtml+xml", maxLinkPageBytes, true)
+	if err != nil {
+		return nil, err
+	}
+	meta := &LinkMetadata{}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return meta, nil
+	}
+	*meta = parseLinkMetadata(body, resp.Request.URL)
+
+	// A missing or broken image still leaves a useful preview
+	if meta.ImageURL != "" {
+		image, _, err := f.get(ctx, meta.ImageURL, "image/*", maxImageSize, false)
+		if err != nil {
+			log.Printf("Error fetching preview image %s: %v", meta.ImageURL, err)
+		} else {
+			meta.Image = image
+		}
+	}
+	return meta, nil
+}
+
+var (
+	titleTagPattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTagPattern  = regexp.MustCompile(`(?is)<meta\s([^>]*)>`)
+	attrPattern     = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// parseLinkMetadata pulls the preview fields out of an HTML document,
+// preferring OpenGraph tags over Twitter cards over plain <title> and
+// description. base resolves a relative og:image.
+func parseLinkMetadata(page []byte, base *url.URL) LinkMetadata {
+	doc := strings.ToValidUTF8(string(page), "")
+	if end := strings.Index(strings.ToLower(doc), "</head>"); end >= 0 {
+		doc = doc[:end]
+	}
+
+	tags := make(map[string]string)
+	for _, m := range metaTagPattern.FindAllStringSubmatch(doc, -1) {
+		attrs := make(map[string]string)
+		for _, a := range attrPattern.FindAllStringSubmatch(m[1], -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+		key := strings.ToLower(attrs["property"])
+		if key == "" {
+			key = strings.ToLower(attrs["name"])
+		}
+		if _, seen := tags[key]; key != "" && !seen {
+			tags[key] = attrs["content"]
+		}
+	}
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := cleanPreviewText(tags[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	var meta LinkMetadata
+	meta.Title = first("og:title", "twitter:title")
+	if meta.Title == "" {
+		if m := titleTagPattern.FindStringSubmatch(doc); m != nil {
+			meta.Title = cleanPreviewText(m[1])
+		}
+	}
+	meta.Title = truncateRunes(meta.Title, maxPreviewTitle)
+	meta.Description = truncateRunes(first("og:description", "twitter:description", "description"), maxPreviewDesc)
+
+	if image := first("og:image", "og:image:url", "twitter:image"); image != "" && base != nil {
+		if ref, err := base.Parse(image); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+			meta.ImageURL = ref.String()
+		}
+	}
+	return meta
+}
+
+// cleanPreviewText decodes entities and collapses whitespace
+func cleanPreviewText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// loadLinkPreviews attaches the preview card of every link post in posts
+func loadLinkPreviews(posts []Post) error {
+	index := make(map[int]int)
+	var args []interface{}
+	for i, post := range posts {
+		if post.PostType == postTypeLink {
+			index[post.ID] = i
+			args = append(args, post.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT post_id, url, title, description, image_path, status
+		FROM link_previews
+		WHERE post_id IN (?`+strings.Repeat(",?", len(args)-1)+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int
+		var link LinkPreview
+		if err := rows.Scan(&postID, &link.URL, &link.Title, &link.Description, &link.ImagePath, &link.Status); err != nil {
+			return err
+		}
+		link.Domain = linkDomain(link.URL)
+		posts[index[postID]].Link = &link
+	}
+	return rows.Err()
+}
+
+// linkPreviewWake nudges the worker when a new link post is created so the
+// preview doesn't wait for the next poll.
+var linkPreviewWake = make(chan struct{}, 1)
+
+func queueLinkPreview() {
+	select {
+	case linkPreviewWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartLinkPreviewWorker fetches previews for pending link posts. It blocks,
+// so run it in its own goroutine. Pending rows survive a restart and are
+// picked up on the next pass. A pass that leaves any of its rows pending,
+// because their outcome couldn't be stored, waits for the next wake-up
+// rather than fetching the same pages again straight away.
+func StartLinkPreviewWorker() {
+	for {
+		for processPendingLinkPreviews() == linkPreviewBatchSize {
+		}
+		select {
+		case <-linkPreviewWake:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// processPendingLinkPreviews fetches one batch of pending previews and
+// returns how many it settled as ready or failed.
+func processPendingLinkPreviews() int {
+	rows, err := db.Query("SELECT post_id, url FROM link_previews WHERE status = 'pending' ORDER BY post_id LIMIT ?", linkPreviewBatchSize)
+	if err != nil {
+		log.Printf("Error loading pending link previews: %v", err)
+		return 0
+	}
+	type job struct {
+		postID int64
+		url    string
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.postID, &j.url); err == nil {
+			jobs = append(jobs, j)
+		}
+	}
+	rows.Close()
+
+	settled := 0
+	for _, j := range jobs {
+		if fetchLinkPreview(j.postID, j.url) {
+			settled++
+		}
+	}
+	return settled
+}
+
+// fetchLinkPreview fetches and stores one preview. The OpenGraph image goes
+// through the same validation as uploads and only its thumbnail is kept, so
+// feeds never hotlink the remote site. A panic while handling the remote
+// page fails the preview rather than the server, which would otherwise hit
+// it again on every start while the preview stays pending. It reports
+// whether the preview is no longer pending.
+func fetchLinkPreview(postID int64, link string) (settled bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic fetching link preview for post %d: %v", postID, r)
+			settled = failLinkPreview(postID)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(LinkPreviewTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	meta, err := previewFetcher.Fetch(ctx, link)
+	if err != nil {
+		log.Printf("Error fetching link preview for post %d: %v", postID, err)
+		return failLinkPreview(postID)
+	}
+
+	var imagePath string
+	if len(meta.Image) > 0 {
+		stored, err := saveUploadedImage(bytes.NewReader(meta.Image))
+		if err != nil {
+			log.Printf("Error storing preview image for post %d: %v", postID, err)
+		} else {
+			imagePath = stored.ThumbnailPath
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE link_previews SET title = ?, description = ?, image_path = ?, status = 'ready', fetched_at = ?
+		WHERE post_id = ?`, meta.Title, meta.Description, imagePath, time.Now(), postID)
+	if err != nil {
+		log.Printf("Error updating link preview: %v", err)
+		return false
+	}
+	return true
+}
+
+// failLinkPreview marks a preview as one that couldn't be fetched and reports
+// whether that was stored
+func failLinkPreview(postID int64) bool {
+	if _, err := db.Exec("UPDATE link_previews SET status = 'failed', fetched_at = ? WHERE post_id = ?", time.Now(), postID); err != nil {
+		log.Printf("Error updating link preview: %v", err)
+		return false
+	}
+	return true
+}
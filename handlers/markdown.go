@@ -0,0 +1,764 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPreviewLength caps how much markdown /api/preview will render in one go
+const maxPreviewLength = 40000
+
+// RenderMarkdown converts CommonMark-style markdown into HTML that is safe to
+// insert into the page. Raw HTML in the source is always escaped rather than
+// passed through, links are limited to http, https, mailto and relative URLs
+// and carry rel="nofollow ugc", and >!spoilers!< are wrapped in a spoiler span.
+func RenderMarkdown(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "�")
+	return strings.TrimSpace(renderBlocks(strings.Split(source, "\n"), false))
+}
+
+var (
+	atxHeadingRe    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreakRe = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fenceRe         = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*)$")
+	setextRe        = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	listMarkerRe    = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])([ \t]+|$)`)
+	quoteRe         = regexp.MustCompile(`^ {0,3}>`)
+	languageRe      = regexp.MustCompile(`[^A-Za-z0-9_+#.-]`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// isQuoteLine reports whether a line opens a block quote. A line starting
+// with >! is a spoiler, not a quote.
+func isQuoteLine(line string) bool {
+	if !quoteRe.MatchString(line) {
+		return false
+	}
+	return !strings.HasPrefix(strings.TrimLeft(line, " "), ">!")
+}
+
+// interruptsParagraph reports whether line starts a block that ends the
+// paragraph being collected.
+func interruptsParagraph(line string) bool {
+	if atxHeadingRe.MatchString(line) || thematicBreakRe.MatchString(line) || fenceRe.MatchString(line) || isQuoteLine(line) {
+		return true
+	}
+	if m := listMarkerRe.FindStringSubmatch(line); m != nil {
+		// Empty items and ordered lists not starting at 1 cannot interrupt
+		// a paragraph, so "2019. What a year" stays plain text.
+		if isBlank(line[len(m[0]):]) {
+			return false
+		}
+		if n, err := strconv.Atoi(strings.TrimRight(m[2], ".)")); err == nil && n != 1 {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// stripIndent removes up to n columns of leading spaces, counting a tab as
+// four columns.
+func stripIndent(line string, n int) string {
+	col := 0
+	for i, r := range line {
+		if col >= n {
+			return line[i:]
+		}
+		switch r {
+		case ' ':
+			col++
+		case '\t':
+			col += 4
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+func indentWidth(line string) int {
+	col := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			col++
+		case '\t':
+			col += 4
+		default:
+			return col
+		}
+	}
+	return col
+}
+
+// renderBlocks renders a sequence of lines as block-level HTML. Paragraphs in
+// tight list items are rendered without <p> tags.
+func renderBlocks(lines []string, tight bool) string {
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		if isBlank(line) {
+			i++
+			continue
+		}
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			indent, fence := len(m[1]), m[2]
+			info := strings.Fields(m[3])
+			var code []string
+			i++
+			for i < len(lines) {
+				closing := strings.TrimSpace(lines[i])
+				if strings.HasPrefix(closing, fence[:1]) && strings.Trim(closing, fence[:1]) == "" && len(closing) >= len(fence) {
+					i++
+					break
+				}
+				code = append(code, stripIndent(lines[i], indent))
+				i++
+			}
+			out.WriteString("<pre><code")
+			if len(info) > 0 {
+				if lang := languageRe.ReplaceAllString(info[0], ""); lang != "" {
+					out.WriteString(` class="language-` + lang + `"`)
+				}
+			}
+			out.WriteString(">")
+			if len(code) > 0 {
+				out.WriteString(html.EscapeString(strings.Join(code, "\n")) + "\n")
+			}
+			out.WriteString("</code></pre>\n")
+			continue
+		}
+
+		if indentWidth(line) >= 4 {
+			var code []string
+			for i < len(lines) && (isBlank(lines[i]) || indentWidth(lines[i]) >= 4) {
+				code = append(code, stripIndent(lines[i], 4))
+				i++
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+			continue
+		}
+
+		if m := atxHeadingRe.FindStringSubmatch(line); m != nil {
+			level := strconv.Itoa(len(m[1]))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+			i++
+			continue
+		}
+
+		if thematicBreakRe.MatchString(line) {
+			out.WriteString("<hr>\n")
+			i++
+			continue
+		}
+
+		if isQuoteLine(line) {
+			var quoted []string
+			for i < len(lines) && isQuoteLine(lines[i]) {
+				rest := strings.TrimLeft(lines[i], " ")[1:]
+				if strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "\t") {
+					rest = rest[1:]
+				}
+				quoted = append(quoted, rest)
+				i++
+			}
+			out.WriteString("<blockquote>\n" + renderBlocks(quoted, false) + "</blockquote>\n")
+			continue
+		}
+
+		if listMarkerRe.MatchString(line) {
+			i = renderList(&out, lines, i)
+			continue
+		}
+
+		// Paragraph, possibly turned into a heading by a setext underline
+		var para []string
+		for i < len(lines) && !isBlank(lines[i]) {
+			if len(para) > 0 {
+				if m := setextRe.FindStringSubmatch(lines[i]); m != nil {
+					level := "2"
+					if m[1][0] == '=' {
+						level = "1"
+					}
+					out.WriteString("<h" + level + ">" + renderInline(strings.Join(para, "\n")) + "</h" + level + ">\n")
+					para = nil
+					i++
+					break
+				}
+				if interruptsParagraph(lines[i]) {
+					break
+				}
+			}
+			para = append(para, strings.TrimLeft(lines[i], " \t"))
+			i++
+		}
+		if len(para) > 0 {
+			content := renderInline(strings.Join(para, "\n"))
+			if tight {
+				out.WriteString(content + "\n")
+			} else {
+				out.WriteString("<p>" + content + "</p>\n")
+			}
+		}
+	}
+	return out.String()
+}
+
+// renderList renders the list starting at lines[start] and returns the index
+// of the first line after it.
+func renderList(out *strings.Builder, lines []string, start int) int {
+	first := listMarkerRe.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	delimiter := first[2][len(first[2])-1:]
+
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		m := listMarkerRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		itemOrdered := m[2][0] >= '0' && m[2][0] <= '9'
+		if itemOrdered != ordered || m[2][len(m[2])-1:] != delimiter {
+			break
+		}
+
+		// Content of the item is indented to the column after the marker
+		contentIndent := len(m[1]) + len(m[2]) + 1
+		if spaces := len(m[3]); spaces > 1 && spaces <= 4 {
+			contentIndent = len(m[1]) + len(m[2]) + spaces
+		}
+		item := []string{lines[i][len(m[0]):]}
+		i++
+
+		for i < len(lines) {
+			if isBlank(lines[i]) {
+				// A blank line continues the item only if indented content follows
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indentWidth(lines[j]) >= contentIndent {
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					loose = true
+					continue
+				}
+				break
+			}
+			if indentWidth(lines[i]) >= contentIndent {
+				item = append(item, stripIndent(lines[i], contentIndent))
+				i++
+				continue
+			}
+			// Lazy continuation of the item's paragraph
+			if !isBlank(item[len(item)-1]) && !interruptsParagraph(lines[i]) && !listMarkerRe.MatchString(lines[i]) {
+				item = append(item, lines[i])
+				i++
+				continue
+			}
+			break
+		}
+		items = append(items, item)
+
+		// Blank lines between items make the list loose
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j > i && j < len(lines) {
+			if next := listMarkerRe.FindStringSubmatch(lines[j]); next != nil && (next[2][0] >= '0' && next[2][0] <= '9') == ordered {
+				loose = true
+				i = j
+			}
+		}
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		n, _ := strconv.Atoi(strings.TrimRight(first[2], ".)"))
+		if n != 1 {
+			out.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			out.WriteString("<ol>\n")
+		}
+	} else {
+		out.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		body := strings.TrimSuffix(renderBlocks(item, !loose), "\n")
+		out.WriteString("<li>" + body + "</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// safeURL validates a link destination, returning it ready to be used as an
+// attribute value. Only http, https, mailto and relative URLs are allowed.
+func safeURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", false
+	}
+	for _, r := range raw {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return "", false
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+	case "":
+		// A relative URL must not smuggle a scheme past the parser
+		if strings.Contains(strings.SplitN(raw, "/", 2)[0], ":") {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return html.EscapeString(u.String()), true
+}
+
+func linkHTML(href, text string) string {
+	return `<a href="` + href + `" rel="nofollow ugc">` + text + `</a>`
+}
+
+// inlineNode is either a run of finished HTML or an emphasis delimiter run
+// still waiting to be matched.
+type inlineNode struct {
+	html     string
+	delim    byte
+	count    int
+	canOpen  bool
+	canClose bool
+	before   []string // tags opened at this delimiter
+	after    []string // tags closed at this delimiter
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// renderInline renders inline markdown (code spans, links, emphasis,
+// strikethrough and spoilers) with all other text HTML-escaped.
+func renderInline(text string) string {
+	return renderInlineLinks(text, true)
+}
+
+func renderInlineLinks(text string, allowLinks bool) string {
+	var nodes []*inlineNode
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			nodes = append(nodes, &inlineNode{html: buf.String()})
+			buf.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			next := text[i+1]
+			if next == '\n' {
+				buf.WriteString("<br>\n")
+				i += 2
+				continue
+			}
+			if next < 128 && isPunct(rune(next)) {
+				buf.WriteString(html.EscapeString(string(next)))
+				i += 2
+				continue
+			}
+			buf.WriteByte('\\')
+			i++
+
+		case c == '`':
+			run := 0
+			for i+run < len(text) && text[i+run] == '`' {
+				run++
+			}
+			fence := strings.Repeat("`", run)
+			end := -1
+			for j := i + run; j < len(text); {
+				k := strings.Index(text[j:], fence)
+				if k == -1 {
+					break
+				}
+				k += j
+				after := k + run
+				if (after >= len(text) || text[after] != '`') && (k == 0 || text[k-1] != '`') {
+					end = k
+					break
+				}
+				for k < len(text) && text[k] == '`' {
+					k++
+				}
+				j = k
+			}
+			if end == -1 {
+				buf.WriteString(fence)
+				i += run
+				continue
+			}
+			code := strings.ReplaceAll(text[i+run:end], "\n", " ")
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			buf.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i = end + run
+
+		case c == '>' && strings.HasPrefix(text[i:], ">!"):
+			end := strings.Index(text[i+2:], "!<")
+			if end == -1 {
+				buf.WriteString("&gt;")
+				i++
+				continue
+			}
+			inner := text[i+2 : i+2+end]
+			buf.WriteString(`<span class="spoiler">` + renderInlineLinks(inner, allowLinks) + `</span>`)
+			i += 2 + end + 2
+
+		case c == '<':
+			end := strings.IndexByte(text[i:], '>')
+			if allowLinks && end > 1 && !strings.ContainsAny(text[i+1:i+end], " \t\n<") {
+				target := text[i+1 : i+end]
+				if strings.Contains(target, "@") && !strings.Contains(target, ":") {
+					target = "mailto:" + target
+				}
+				if href, ok := safeURL(target); ok && strings.Contains(target, ":") {
+					buf.WriteString(linkHTML(href, html.EscapeString(text[i+1:i+end])))
+					i += end + 1
+					continue
+				}
+			}
+			buf.WriteString("&lt;")
+			i++
+
+		case c == '[' || (c == '!' && i+1 < len(text) && text[i+1] == '['):
+			open := i
+			if c == '!' {
+				open++
+			}
+			label, dest, next, ok := parseLink(text, open)
+			if !ok {
+				buf.WriteString(html.EscapeString(text[i : open+1]))
+				i = open + 1
+				continue
+			}
+			// Images are shown as plain links so posts can't embed
+			// third-party trackers.
+			href, safe := safeURL(dest)
+			if allowLinks && safe {
+				buf.WriteString(linkHTML(href, renderInlineLinks(label, false)))
+			} else {
+				buf.WriteString(renderInlineLinks(label, false))
+			}
+			i = next
+
+		case c == '*' || c == '_' || c == '~':
+			run := 0
+			for i+run < len(text) && text[i+run] == c {
+				run++
+			}
+			if c == '~' && run != 2 {
+				buf.WriteString(text[i : i+run])
+				i += run
+				continue
+			}
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			if i == 0 {
+				prev = ' '
+			}
+			next, _ := utf8.DecodeRuneInString(text[i+run:])
+			if i+run >= len(text) {
+				next = ' '
+			}
+			leftFlanking := !unicode.IsSpace(next) && (!isPunct(next) || unicode.IsSpace(prev) || isPunct(prev))
+			rightFlanking := !unicode.IsSpace(prev) && (!isPunct(prev) || unicode.IsSpace(next) || isPunct(next))
+			node := &inlineNode{delim: c, count: run}
+			if c == '_' {
+				node.canOpen = leftFlanking && (!rightFlanking || isPunct(prev))
+				node.canClose = rightFlanking && (!leftFlanking || isPunct(next))
+			} else {
+				node.canOpen = leftFlanking
+				node.canClose = rightFlanking
+			}
+			flush()
+			nodes = append(nodes, node)
+			i += run
+
+		case c == 'h' && allowLinks && (strings.HasPrefix(text[i:], "http://") || strings.HasPrefix(text[i:], "https://")):
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			if i > 0 && !unicode.IsSpace(prev) && prev != '(' {
+				buf.WriteByte(c)
+				i++
+				continue
+			}
+			end := i
+			for end < len(text) && !unicode.IsSpace(rune(text[end])) && text[end] != '<' {
+				end++
+			}
+			for end > i && strings.ContainsRune(".,:;!?)'\"*_~", rune(text[end-1])) {
+				end--
+			}
+			if href, ok := safeURL(text[i:end]); ok {
+				buf.WriteString(linkHTML(href, html.EscapeString(text[i:end])))
+				i = end
+				continue
+			}
+			buf.WriteByte(c)
+			i++
+
+		case c == '\n':
+			// Two trailing spaces make a hard line break
+			line := buf.String()
+			trimmed := strings.TrimRight(line, " ")
+			hard := len(line)-len(trimmed) >= 2
+			buf.Reset()
+			buf.WriteString(trimmed)
+			if hard {
+				buf.WriteString("<br>")
+			}
+			buf.WriteByte('\n')
+			i++
+
+		default:
+			r, size := utf8.DecodeRuneInString(text[i:])
+			buf.WriteString(html.EscapeString(string(r)))
+			i += size
+		}
+	}
+	flush()
+
+	matchEmphasis(nodes)
+
+	var out strings.Builder
+	for _, n := range nodes {
+		if n.delim == 0 {
+			out.WriteString(n.html)
+			continue
+		}
+		for _, tag := range n.after {
+			out.WriteString(tag)
+		}
+		out.WriteString(strings.Repeat(string(n.delim), n.count))
+		for j := len(n.before) - 1; j >= 0; j-- {
+			out.WriteString(n.before[j])
+		}
+	}
+	return out.String()
+}
+
+// matchEmphasis pairs delimiter runs following the CommonMark rules: each
+// closer is matched with the nearest compatible opener before it, using two
+// characters for <strong> when both runs allow it.
+func matchEmphasis(nodes []*inlineNode) {
+	for ci, closer := range nodes {
+		if closer.delim == 0 || !closer.canClose {
+			continue
+		}
+		for closer.count > 0 {
+			oi := -1
+			for j := ci - 1; j >= 0; j-- {
+				opener := nodes[j]
+				if opener.delim != closer.delim || !opener.canOpen || opener.count == 0 {
+					continue
+				}
+				// The "rule of 3" from the spec
+				if (opener.canClose || closer.canOpen) && (opener.count+closer.count)%3 == 0 &&
+					(opener.count%3 != 0 || closer.count%3 != 0) && closer.delim != '~' {
+					continue
+				}
+				oi = j
+				break
+			}
+			if oi == -1 {
+				break
+			}
+			opener := nodes[oi]
+
+			use, open, close := 1, "<em>", "</em>"
+			switch {
+			case closer.delim == '~':
+				use, open, close = 2, "<del>", "</del>"
+			case opener.count >= 2 && closer.count >= 2:
+				use, open, close = 2, "<strong>", "</strong>"
+			}
+			opener.count -= use
+			closer.count -= use
+			opener.before = append(opener.before, open)
+			closer.after = append(closer.after, close)
+
+			// Delimiters between the pair can no longer match anything
+			for j := oi + 1; j < ci; j++ {
+				if nodes[j].delim != 0 {
+					nodes[j].canOpen, nodes[j].canClose = false, false
+				}
+			}
+		}
+	}
+}
+
+// parseLink parses [label](destination "title") starting at the '[' at index
+// open. It returns the label, destination and the index just past the link.
+func parseLink(text string, open int) (label, dest string, next int, ok bool) {
+	depth := 0
+	closeBracket := -1
+	for j := open; j < len(text) && closeBracket == -1; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '`':
+			if end := strings.IndexByte(text[j+1:], '`'); end != -1 {
+				j += end + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = j
+			}
+		}
+	}
+	if closeBracket == -1 || closeBracket+1 >= len(text) || text[closeBracket+1] != '(' {
+		return "", "", 0, false
+	}
+
+	rest := text[closeBracket+2:]
+	end := 0
+	for end < len(rest) && (rest[end] == ' ' || rest[end] == '\t' || rest[end] == '\n') {
+		end++
+	}
+	if end < len(rest) && rest[end] == '<' {
+		gt := strings.IndexByte(rest[end:], '>')
+		if gt == -1 {
+			return "", "", 0, false
+		}
+		dest = rest[end+1 : end+gt]
+		end += gt + 1
+	} else {
+		start, parens := end, 0
+		for end < len(rest) {
+			ch := rest[end]
+			if ch == ' ' || ch == '\t' || ch == '\n' {
+				break
+			}
+			if ch == '(' {
+				parens++
+			} else if ch == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+			end++
+		}
+		dest = rest[start:end]
+	}
+	// Skip an optional title; it isn't rendered
+	for end < len(rest) && (rest[end] == ' ' || rest[end] == '\t' || rest[end] == '\n') {
+		end++
+	}
+	if end < len(rest) && (rest[end] == '"' || rest[end] == '\'') {
+		q := rest[end]
+		closing := strings.IndexByte(rest[end+1:], q)
+		if closing == -1 {
+			return "", "", 0, false
+		}
+		end += closing + 2
+		for end < len(rest) && (rest[end] == ' ' || rest[end] == '\t' || rest[end] == '\n') {
+			end++
+		}
+	}
+	if end >= len(rest) || rest[end] != ')' {
+		return "", "", 0, false
+	}
+	return text[open+1 : closeBracket], dest, closeBracket + 2 + end + 1, true
+}
+
+// backfillContentHTML renders markdown for posts and comments created before
+// content_html existed.
+func backfillContentHTML() {
+	for _, table := range []string{"posts", "comments"} {
+		rows, err := db.Query("SELECT id, content FROM " + table + " WHERE content_html = '' AND content != ''")
+		if err != nil {
+			log.Printf("Error loading %s to render: %v", table, err)
+			continue
+		}
+		pending := map[int]string{}
+		for rows.Next() {
+			var id int
+			var content string
+			if err := rows.Scan(&id, &content); err == nil {
+				pending[id] = content
+			}
+		}
+		rows.Close()
+
+		for id, content := range pending {
+			if _, err := db.Exec("UPDATE "+table+" SET content_html = ? WHERE id = ?", RenderMarkdown(content), id); err != nil {
+				log.Printf("Error rendering %s %d: %v", table, id, err)
+			}
+		}
+	}
+}
+
+// PreviewHandler renders markdown for the post and comment composers without
+// saving anything. It accepts {"content": "..."} and returns {"html": "..."}.
+func PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to preview", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPreviewLength*2)).Decode(&request); err != nil {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(request.Content) > maxPreviewLength {
+		respondWithError(w, "Content is too long to preview", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"html":    RenderMarkdown(request.Content),
+	})
+}
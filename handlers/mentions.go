@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"database/sql"
+	"html"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMentions caps how many users one post, comment or message can mention
+const maxMentions = 10
+
+// maxMentionLength is the longest name a mention can carry
+const maxMentionLength = 32
+
+// mentionRe matches an @name. mentionSpans also checks what comes before the
+// @ so that email addresses don't count.
+var mentionRe = regexp.MustCompile(`@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// mentionedUser is a user a piece of text mentions, as Name was written
+type mentionedUser struct {
+	ID       string
+	Username string
+	Nickname string
+	Name     string
+}
+
+// Mention is a resolved mention as returned with chat messages
+type Mention struct {
+	Username   string `json:"username"`
+	Nickname   string `json:"nickname"`
+	ProfileURL string `json:"profile_url"`
+}
+
+// mentionSource is what a mention was made in. Comments carry their post
+// too, so notifications can show its title.
+type mentionSource struct {
+	postID, commentID, messageID int64
+}
+
+// column returns the mentions column identifying the source and its value
+func (s mentionSource) column() (string, int64) {
+	switch {
+	case s.messageID != 0:
+		return "message_id", s.messageID
+	case s.commentID != 0:
+		return "comment_id", s.commentID
+	}
+	return "post_id", s.postID
+}
+
+// profileLink is where a mention of username links to in the app
+func profileLink(username string) string {
+	return "#/users/" + url.PathEscape(username)
+}
+
+// mentionSpans returns the [start, end) byte offsets of each name mentioned
+// in plain text, leaving out the @. A mention must not follow a letter,
+// digit or one of _@.- and loses any trailing dots and dashes.
+func mentionSpans(text string) [][2]int {
+	var spans [][2]int
+	for _, m := range mentionRe.FindAllStringSubmatchIndex(text, -1) {
+		if prev, _ := utf8.DecodeLastRuneInString(text[:m[0]]); m[0] > 0 &&
+			(unicode.IsLetter(prev) || unicode.IsDigit(prev) || strings.ContainsRune("_@.-", prev)) {
+			continue
+		}
+		end := m[3]
+		for end > m[2] && strings.ContainsRune(".-", rune(text[end-1])) {
+			end--
+		}
+		if utf8.RuneCountInString(text[m[2]:end]) <= maxMentionLength {
+			spans = append(spans, [2]int{m[2], end})
+		}
+	}
+	return spans
+}
+
+// mentionNames returns the distinct names mentioned in plain text, compared
+// without regard to case, up to maxMentions.
+func mentionNames(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, span := range mentionSpans(text) {
+		name := text[span[0]:span[1]]
+		if key := strings.ToLower(name); !seen[key] && len(names) < maxMentions {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mapHTMLText calls fn on each run of text in rendered HTML outside links and
+// code, replacing the run with what fn returns. Tags pass through unchanged.
+func mapHTMLText(rendered string, fn func(string) string) string {
+	var out strings.Builder
+	skip := 0
+	for len(rendered) > 0 {
+		if rendered[0] == '<' {
+			end := strings.IndexByte(rendered, '>')
+			if end == -1 {
+				end = len(rendered) - 1
+			}
+			tag := rendered[:end+1]
+			name := ""
+			if fields := strings.Fields(strings.Trim(tag, "<>/ ")); len(fields) > 0 {
+				name = strings.ToLower(fields[0])
+			}
+			if name == "a" || name == "code" || name == "pre" {
+				if strings.HasPrefix(tag, "</") {
+					skip = max(skip-1, 0)
+				} else {
+					skip++
+				}
+			}
+			out.WriteString(tag)
+			rendered = rendered[end+1:]
+			continue
+		}
+		end := strings.IndexByte(rendered, '<')
+		if end == -1 {
+			end = len(rendered)
+		}
+		if skip > 0 {
+			out.WriteString(rendered[:end])
+		} else {
+			out.WriteString(fn(rendered[:end]))
+		}
+		rendered = rendered[end:]
+	}
+	return out.String()
+}
+
+// mentionNamesInHTML is mentionNames for rendered markdown, leaving out
+// names inside links and code.
+func mentionNamesInHTML(rendered string) []string {
+	var text strings.Builder
+	mapHTMLText(rendered, func(run string) string {
+		text.WriteString(html.UnescapeString(run))
+		text.WriteByte(' ')
+		return run
+	})
+	return mentionNames(text.String())
+}
+
+// linkMentions turns the mentions of users in rendered markdown into links
+// to their profiles.
+func linkMentions(rendered string, users []mentionedUser) string {
+	if len(users) == 0 {
+		return rendered
+	}
+	byName := make(map[string]mentionedUser, len(users))
+	for _, u := range users {
+		byName[strings.ToLower(u.Name)] = u
+	}
+	return mapHTMLText(rendered, func(run string) string {
+		var out strings.Builder
+		last := 0
+		for _, span := range mentionSpans(run) {
+			u, ok := byName[strings.ToLower(run[span[0]:span[1]])]
+			if !ok {
+				continue
+			}
+			out.WriteString(run[last : span[0]-1])
+			out.WriteString(`<a href="` + html.EscapeString(profileLink(u.Username)) + `" class="mention">@` +
+				run[span[0]:span[1]] + `</a>`)
+			last = span[1]
+		}
+		out.WriteString(run[last:])
+		return out.String()
+	})
+}
+
+// resolveMentions looks up the users behind names, matching nicknames first
+// and then usernames, without regard to case. Names that match no one, or
+// more than one user, are left out.
+func resolveMentions(names []string) ([]mentionedUser, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	lowered := make([]interface{}, len(names))
+	for i, name := range names {
+		lowered[i] = strings.ToLower(name)
+	}
+	in := "(?" + strings.Repeat(",?", len(names)-1) + ")"
+	rows, err := db.Query(`
+		SELECT id, username, COALESCE(nickname, '') FROM users
+		WHERE lower(nickname) IN `+in+` OR lower(username) IN `+in,
+		append(lowered, lowered...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byNickname := make(map[string][]mentionedUser)
+	byUsername := make(map[string][]mentionedUser)
+	for rows.Next() {
+		var u mentionedUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.Nickname); err != nil {
+			return nil, err
+		}
+		if u.Nickname != "" {
+			byNickname[strings.ToLower(u.Nickname)] = append(byNickname[strings.ToLower(u.Nickname)], u)
+		}
+		byUsername[strings.ToLower(u.Username)] = append(byUsername[strings.ToLower(u.Username)], u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var users []mentionedUser
+	for _, name := range names {
+		matches := byNickname[strings.ToLower(name)]
+		if len(matches) == 0 {
+			matches = byUsername[strings.ToLower(name)]
+		}
+		if len(matches) == 1 {
+			u := matches[0]
+			u.Name = name
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// renderWithMentions renders markdown with its mentions linked to profiles
+// and returns the users mentioned. A failed lookup is logged and leaves the
+// mentions as plain text.
+func renderWithMentions(source string) (string, []mentionedUser) {
+	rendered := RenderMarkdown(source)
+	users, err := resolveMentions(mentionNamesInHTML(rendered))
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+		return rendered, nil
+	}
+	return linkMentions(rendered, users), users
+}
+
+// recordMentions makes the mentions table hold exactly users for source and
+// notifies the users newly mentioned in it, unless they turned mention
+// notifications off or blocked the actor. Nobody is notified of mentioning
+// themselves, and a
+// user's mention notification for source is the record that they were told,
+// so editing them out and back in doesn't notify them again.
+func recordMentions(source mentionSource, actorID string, users []mentionedUser) error {
+	column, id := source.column()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Mentions edited out of the source go; "" keeps the list from being empty
+	args := []interface{}{id, ""}
+	for _, u := range users {
+		args = append(args, u.ID)
+	}
+	if _, err := tx.Exec("DELETE FROM mentions WHERE "+column+" = ? AND user_id NOT IN (?"+
+		strings.Repeat(",?", len(users))+")", args...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, u := range users {
+		if u.ID == actorID {
+			continue
+		}
+		result, err := tx.Exec("INSERT OR IGNORE INTO mentions (user_id, actor_id, "+column+", created_at) VALUES (?, ?, ?, ?)",
+			u.ID, actorID, id, now)
+		if err != nil {
+			return err
+		}
+		if added, _ := result.RowsAffected(); added == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, message_id, created_at)
+			SELECT id, ?, ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), ?
+			FROM users WHERE id = ? AND mention_notifications = 1 AND `+notBlockedByCondition+`
+			AND NOT EXISTS (SELECT 1 FROM notifications n WHERE n.user_id = users.id AND n.type = ?
+				AND n.post_id IS NULLIF(?, 0) AND n.comment_id IS NULLIF(?, 0) AND n.message_id IS NULLIF(?, 0))`,
+			notificationMention, actorID, source.postID, source.commentID, source.messageID, now, u.ID, actorID,
+			notificationMention, source.postID, source.commentID, source.messageID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordPostMentions records the mentions in a post's content once it is
+// published.
+func recordPostMentions(postID int64) error {
+	var authorID, content string
+	err := db.QueryRow("SELECT user_id, content FROM posts WHERE id = ? AND status = 'published'", postID).Scan(&authorID, &content)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	users, err := resolveMentions(mentionNamesInHTML(RenderMarkdown(content)))
+	if err != nil {
+		return err
+	}
+	return recordMentions(mentionSource{postID: postID}, authorID, users)
+}
+
+// onPostPublished does what follows a post going live: notifying followers
+// and the users it mentions. Failures are logged.
+func onPostPublished(postID int64) {
+	if err := notifyFollowersOfPost(postID); err != nil {
+		log.Printf("Error notifying followers: %v", err)
+	}
+	if err := recordPostMentions(postID); err != nil {
+		log.Printf("Error recording mentions in post %d: %v", postID, err)
+	}
+}
+
+// loadMessageMentions sets Mentions on chat messages
+func loadMessageMentions(messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	rows, err := db.Query(`
+		SELECT m.message_id, u.username, COALESCE(u.nickname, '')
+		FROM mentions m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.message_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int][]Mention)
+	for rows.Next() {
+		var id int
+		var m Mention
+		if err := rows.Scan(&id, &m.Username, &m.Nickname); err != nil {
+			return err
+		}
+		m.ProfileURL = profileLink(m.Username)
+		byMessage[id] = append(byMessage[id], m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Mentions = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// chatMentions resolves the mentions in a chat message. Only the recipient
+// can be mentioned, since nobody else can read the conversation.
+func chatMentions(content, recipientID string) ([]mentionedUser, []Mention, error) {
+	users, err := resolveMentions(mentionNames(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, u := range users {
+		if u.ID == recipientID {
+			return []mentionedUser{u}, []Mention{{Username: u.Username, Nickname: u.Nickname, ProfileURL: profileLink(u.Username)}}, nil
+		}
+	}
+	return nil, nil, nil
+}
@@ -36,21 +36,41 @@ type Message struct {
 	IsRead         bool      `json:"is_read"`
 	SenderUsername string    `json:"sender_username"`
 	SenderAvatar   string    `json:"sender_avatar"`
+	SenderKarma    int       `json:"sender_karma"`
 	IsOwner        bool      `json:"is_owner,omitempty"`
+	Mentions       []Mention `json:"mentions,omitempty"`
 }
 // Post represents a post in the forum
 type Post struct {
 	ID             int
 	UserID         string
 	Title          string
-	Content        string
-	ImagePath      string // New field for image path
+	Content        string // Markdown source as written by the author
+	ContentHTML    string // Sanitized HTML rendered from Content
+	ImagePath      string       // First attachment, kept for single-image clients
+	ThumbnailPath  string       // Downscaled copy of ImagePath for feeds
+	Attachments    []Attachment // Images in display order
+	Status         string       // draft, scheduled or published
+	PostType       string       // text, poll or link
+	Poll           *Poll        // Set on poll posts only
+	Link           *LinkPreview // Set on link posts only
+	PublishAt      *time.Time   // When a scheduled post goes live
 	Categories     string
 	Username       string
+	AuthorKarma    int // The author's post and comment karma
 	CreatedAt      time.Time
 	CreatedAtHuman string
 	LikeCount      int // Number of likes
 	DislikeCount   int
+	CommentCount   int       // Number of comments, replies included
+	NSFW           bool      // Marked not safe for work
+	Spoiler        bool      // Marked as containing spoilers
+	Blur           bool      // Whether clients should blur it for the viewer
+	Pinned         bool      // Pinned in the feed it was served in
+	Locked         bool      // Closed to new comments and votes by a moderator
+	Archived       bool      // Closed to new comments and votes by age
+	Saved          bool      // Whether the viewer saved this post
+	UserVote       string    // The viewer's vote: up, down or none
 	Comments       []Comment // List of comments for this post
 }
 
@@ -67,15 +87,27 @@ type Comment struct {
 	PostID         int
 	UserID         string // Changed from int to string to match User.ID
 	Content        string
+	ContentHTML    string    // Sanitized HTML rendered from Content
 	CreatedAt      time.Time // Original time
 	CreatedAtHuman string    // Human-readable time
 	Username       string
-	ParentID       *int      // Parent comment ID, null for top-level comments
-	Replies        []Comment // List of reply comments
-	ReplyCount     int       // Number of replies
-	LikeCount      int       // Number of likes
-	DislikeCount   int       // Number of dislikes
-	UserLiked      *bool     // Whether the current user liked this comment
+	AuthorKarma    int        // The author's post and comment karma
+	ParentID       *int       // Parent comment ID, null for top-level comments
+	Replies        []Comment  // List of reply comments
+	ReplyCount     int        // Number of replies
+	Depth          int        // Nesting level below the comments it was loaded with
+	ContinueThread bool       // Replies were cut off at the maximum depth; load them by ID
+	MoreReplies    int        // Replies left out of a page of comments
+	RepliesCursor  string     // Cursor for loading the replies left out
+	LikeCount      int        // Number of likes
+	DislikeCount   int        // Number of dislikes
+	UserLiked      *bool      // Whether the current user liked this comment
+	UserVote       string     // The current user's vote: up, down or none
+	Saved          bool       // Whether the current user saved this comment
+	EditedAt       *time.Time // Last edit after the grace period, nil if none
+	Deleted        bool       // Deleted; Content and Username are placeholders
+	CanEdit        bool       // Whether the current user may edit this comment
+	CanDelete      bool       // Whether the current user may delete this comment
 }
 
 // Session represents a user session
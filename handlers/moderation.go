@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siteWidePin is the pinned_posts category of posts pinned across the site
+const siteWidePin = ""
+
+// Reasons a post no longer accepts comments or votes
+const (
+	errPostLocked   = "This post is locked"
+	errPostArchived = "This post is archived"
+)
+
+// canModeratePost reports whether the user moderates one of the post's
+// communities or is a site admin.
+func canModeratePost(postID int, userID string) (bool, error) {
+	var allowed bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM post_categories pc
+			JOIN communities c ON c.name = pc.category
+			JOIN community_moderators m ON m.community_id = c.id
+			WHERE pc.post_id = ? AND m.user_id = ?
+		)`, postID, userID).Scan(&allowed)
+	if err != nil {
+		return false, err
+	}
+	return allowed || isSiteAdmin(userID), nil
+}
+
+// lockReason returns why the post rejects new comments and votes, or ""
+// when it accepts them. Drafts and scheduled posts can't be commented or
+// voted on by anyone, so like missing posts they give sql.ErrNoRows.
+func lockReason(postID int) (string, error) {
+	return scanLockReason(db.QueryRow("SELECT locked, archived FROM posts WHERE id = ? AND status = 'published'", postID))
+}
+
+// scanLockReason reads the locked and archived flags of a post that must
+// exist and returns lockReason's reason
+func scanLockReason(row *sql.Row) (string, error) {
+	var locked, archived bool
+	if err := row.Scan(&locked, &archived); err != nil {
+		return "", err
+	}
+	switch {
+	case locked:
+		return errPostLocked, nil
+	case archived:
+		return errPostArchived, nil
+	}
+	return "", nil
+}
+
+// loadPostState sets Locked and Archived on posts
+func loadPostState(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	rows, err := db.Query(`
+		SELECT id, locked, archived FROM posts
+		WHERE id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type state struct{ locked, archived bool }
+	byPost := make(map[int]state)
+	for rows.Next() {
+		var id int
+		var s state
+		if err := rows.Scan(&id, &s.locked, &s.archived); err != nil {
+			return err
+		}
+		byPost[id] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range posts {
+		s := byPost[posts[i].ID]
+		posts[i].Locked, posts[i].Archived = s.locked, s.archived
+	}
+	return nil
+}
+
+// archiveOldPosts archives published posts older than ArchiveAfterDays and
+// returns how many it archived. A setting of 0 turns archiving off.
+func archiveOldPosts(now time.Time) (int64, error) {
+	if ArchiveAfterDays <= 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -ArchiveAfterDays)
+	result, err := db.Exec(`
+		UPDATE posts SET archived = 1
+		WHERE archived = 0 AND status = 'published' AND julianday(created_at) <= julianday(?)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// postIDFromPath parses the {id} path value, writing a 404 when no such post
+// exists.
+func postIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || postID <= 0 {
+		respondWithError(w, "Invalid post ID", http.StatusBadRequest)
+		return 0, false
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists); err != nil {
+		log.Printf("Error checking post: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return 0, false
+	} else if !exists {
+		respondWithError(w, "Post not found", http.StatusNotFound)
+		return 0, false
+	}
+	return postID, true
+}
+
+// PinPostHandler pins post {id} on POST and unpins it on DELETE. With
+// {"community": name} the pin applies to that community and needs one of
+// its moderators; without it the pin is site-wide and needs a site admin.
+func PinPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+	postID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Community string `json:"community"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+	community := strings.TrimSpace(strings.ToLower(request.Community))
+
+	if community == siteWidePin {
+		if !isSiteAdmin(userID) {
+			respondWithError(w, "Only site admins can pin posts site-wide", http.StatusForbidden)
+			return
+		}
+	} else {
+		var communityID int64
+		var inCommunity bool
+		err := db.QueryRow(`
+			SELECT c.id, EXISTS(SELECT 1 FROM post_categories WHERE post_id = ? AND category = c.name)
+			FROM communities c WHERE c.name = ?`, postID, community).Scan(&communityID, &inCommunity)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, "Unknown community", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error looking up community: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !isCommunityModerator(communityID, userID) {
+			respondWithError(w, "Only moderators can pin posts", http.StatusForbidden)
+			return
+		}
+		if !inCommunity {
+			respondWithError(w, "The post is not in that community", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	if r.Method == http.MethodPost {
+		_, err = db.Exec("INSERT OR IGNORE INTO pinned_posts (post_id, category, pinned_by, created_at) VALUES (?, ?, ?, ?)",
+			postID, community, userID, time.Now())
+	} else {
+		_, err = db.Exec("DELETE FROM pinned_posts WHERE post_id = ? AND category = ?", postID, community)
+	}
+	if err != nil {
+		log.Printf("Error updating pin: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pinned":  r.Method == http.MethodPost,
+	})
+}
+
+// LockPostHandler locks post {id} against new comments and votes on POST
+// and unlocks it on DELETE. Only its community moderators may do this.
+func LockPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+	postID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canModeratePost(postID, userID)
+	if err != nil {
+		log.Printf("Error checking moderator: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !allowed {
+		respondWithError(w, "Only moderators can lock posts", http.StatusForbidden)
+		return
+	}
+
+	locked := r.Method == http.MethodPost
+	if _, err := db.Exec("UPDATE posts SET locked = ? WHERE id = ?", locked, postID); err != nil {
+		log.Printf("Error locking post: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"locked":  locked,
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notification types
+const (
+	notificationNewPost = "new_post" // Someone the user follows published a post
+	notificationMention = "mention"  // Someone mentioned the user
+)
+
+// maxNotificationsLimit is the largest page of notifications, and so the most
+// IDs a client can mark read at once
+const maxNotificationsLimit = 100
+
+// publishedNotification is the condition on notifications n that keeps out
+// those about posts that aren't published, whose titles are private
+const publishedNotification = "(n.post_id IS NULL OR EXISTS(SELECT 1 FROM posts WHERE id = n.post_id AND status = 'published'))"
+
+// Notification is one entry in a user's notification list
+type Notification struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	Actor     string    `json:"actor"` // Username of whoever caused it
+	PostID    *int64    `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	CommentID *int64    `json:"comment_id"`
+	MessageID *int64    `json:"message_id"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// notifyFollowersOfPost tells every follower who asked for it that postID was
+// published. Call it once the post is live.
+func notifyFollowersOfPost(postID int64) error {
+	_, err := db.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, post_id, created_at)
+		SELECT f.follower_id, ?, p.user_id, p.id, ?
+		FROM posts p
+		JOIN follows f ON f.followee_id = p.user_id AND f.notify = 1
+		WHERE p.id = ? AND p.status = 'published'`,
+		notificationNewPost, time.Now(), postID)
+	return err
+}
+
+// NotificationsHandler lists the current user's notifications, newest first,
+// with the number still unread. ?unread=1 lists only unread ones.
+// Notifications about posts that aren't published are left out.
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to see notifications", http.StatusUnauthorized)
+		return
+	}
+	page, limit, offset := parsePagination(r, 20, maxNotificationsLimit)
+
+	where := "n.user_id = ? AND " + publishedNotification
+	if formBool(r.URL.Query().Get("unread")) {
+		where += " AND n.is_read = 0"
+	}
+
+	var unread int
+	if err := db.QueryRow("SELECT COUNT(*) FROM notifications n WHERE n.user_id = ? AND n.is_read = 0 AND "+publishedNotification,
+		userID).Scan(&unread); err != nil {
+		log.Printf("Error counting notifications: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT n.id, n.type, COALESCE(u.username, ''), n.post_id, COALESCE(p.title, ''), n.comment_id, n.message_id, n.is_read, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		LEFT JOIN posts p ON p.id = n.post_id
+		WHERE `+where+`
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ? OFFSET ?`, userID, limit+1, offset)
+	if err != nil {
+		log.Printf("Error fetching notifications: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var postID, commentID, messageID sql.NullInt64
+		if err := rows.Scan(&n.ID, &n.Type, &n.Actor, &postID, &n.PostTitle, &commentID, &messageID, &n.Read, &n.CreatedAt); err != nil {
+			log.Printf("Error scanning notification: %v", err)
+			continue
+		}
+		if postID.Valid {
+			n.PostID = &postID.Int64
+		}
+		if commentID.Valid {
+			n.CommentID = &commentID.Int64
+		}
+		if messageID.Valid {
+			n.MessageID = &messageID.Int64
+		}
+		notifications = append(notifications, n)
+	}
+	hasMore := len(notifications) > limit
+	if hasMore {
+		notifications = notifications[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"notifications": notifications,
+		"unread":        unread,
+		"page":          page,
+		"limit":         limit,
+		"has_more":      hasMore,
+	})
+}
+
+// MarkNotificationsReadHandler marks the given notifications, up to
+// maxNotificationsLimit of them, or with {"all": true} every notification, as
+// read.
+func MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (!request.All && len(request.IDs) == 0) {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if !request.All && len(request.IDs) > maxNotificationsLimit {
+		respondWithError(w, fmt.Sprintf("At most %d notifications can be marked at once", maxNotificationsLimit), http.StatusBadRequest)
+		return
+	}
+
+	query := "UPDATE notifications SET is_read = 1 WHERE user_id = ?"
+	args := []interface{}{userID}
+	if !request.All {
+		query += " AND id IN (?" + strings.Repeat(",?", len(request.IDs)-1) + ")"
+		for _, id := range request.IDs {
+			args = append(args, id)
+		}
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
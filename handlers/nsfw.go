@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// How a viewer sees NSFW posts
+const (
+	nsfwShow = "show"
+	nsfwBlur = "blur"
+	nsfwHide = "hide"
+)
+
+// adultAge is the age below which NSFW posts are always hidden
+const adultAge = 18
+
+// validNSFWPreference reports whether value is a mode users may choose
+func validNSFWPreference(value string) bool {
+	return value == nsfwShow || value == nsfwBlur || value == nsfwHide
+}
+
+// nsfwMode returns how viewerID sees NSFW posts: their own preference, or
+// hide for anonymous visitors and users under adultAge.
+func nsfwMode(viewerID string) (string, error) {
+	if viewerID == "" {
+		return nsfwHide, nil
+	}
+	var age sql.NullInt64
+	var preference string
+	err := db.QueryRow("SELECT age, nsfw_preference FROM users WHERE id = ?", viewerID).Scan(&age, &preference)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nsfwHide, nil
+	} else if err != nil {
+		return "", err
+	}
+	if !age.Valid || age.Int64 < adultAge || !validNSFWPreference(preference) {
+		return nsfwHide, nil
+	}
+	return preference, nil
+}
+
+// loadContentWarnings sets the NSFW and spoiler flags on posts, and Blur on
+// those a client should show blurred to viewerID: spoilers always, NSFW
+// posts when the viewer chose to blur them.
+func loadContentWarnings(posts []Post, viewerID string) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	mode, err := nsfwMode(viewerID)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]interface{}, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	rows, err := db.Query(`
+		SELECT id, is_nsfw, is_spoiler FROM posts
+		WHERE id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type flags struct{ nsfw, spoiler bool }
+	byPost := make(map[int]flags)
+	for rows.Next() {
+		var id int
+		var f flags
+		if err := rows.Scan(&id, &f.nsfw, &f.spoiler); err != nil {
+			return err
+		}
+		byPost[id] = f
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range posts {
+		f := byPost[posts[i].ID]
+		posts[i].NSFW, posts[i].Spoiler = f.nsfw, f.spoiler
+		posts[i].Blur = f.spoiler || (f.nsfw && mode != nsfwShow)
+	}
+	return nil
+}
+
+// visiblePostCondition holds for the posts, referred to as p, whose
+// comments a viewer may read: published posts, less NSFW ones when the
+// viewer hides them. It takes the viewer's nsfwMode.
+const visiblePostCondition = "p.status = 'published' AND (p.is_nsfw = 0 OR ? != '" + nsfwHide + "')"
+
+// postVisible reports whether viewerID may read the comments on postID, as
+// visiblePostCondition decides
+func postVisible(postID int, viewerID string) (bool, error) {
+	mode, err := nsfwMode(viewerID)
+	if err != nil {
+		return false, err
+	}
+	var visible bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts p WHERE p.id = ? AND "+visiblePostCondition+")", postID, mode).Scan(&visible)
+	return visible, err
+}
+
+// withoutNSFW drops the NSFW posts when mode is hide. Feeds filter them in
+// SQL; this is for lists built some other way.
+func withoutNSFW(posts []Post, mode string) []Post {
+	if mode != nsfwHide {
+		return posts
+	}
+	kept := posts[:0]
+	for _, post := range posts {
+		if !post.NSFW {
+			kept = append(kept, post)
+		}
+	}
+	return kept
+}
+
+// canFlagPost reports whether the user may change a post's content
+// warnings: its author, a moderator of one of its communities, or a site
+// admin.
+func canFlagPost(postID int, userID string) (bool, error) {
+	var isAuthor bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND user_id = ?)", postID, userID).Scan(&isAuthor)
+	if err != nil || isAuthor {
+		return isAuthor, err
+	}
+	return canModeratePost(postID, userID)
+}
+
+// PostFlagsHandler sets the NSFW and spoiler flags of post {id} from
+// {"nsfw": bool, "spoiler": bool}. Either may be left out to keep it.
+func PostFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in", http.StatusUnauthorized)
+		return
+	}
+	postID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		NSFW    *bool `json:"nsfw"`
+		Spoiler *bool `json:"spoiler"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (request.NSFW == nil && request.Spoiler == nil) {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := canFlagPost(postID, userID)
+	if err != nil {
+		log.Printf("Error checking flag permission: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !allowed {
+		respondWithError(w, "Only the author or a moderator can change content warnings", http.StatusForbidden)
+		return
+	}
+
+	var nsfw, spoiler bool
+	err = db.QueryRow(`
+		UPDATE posts SET is_nsfw = COALESCE(?, is_nsfw), is_spoiler = COALESCE(?, is_spoiler)
+		WHERE id = ?
+		RETURNING is_nsfw, is_spoiler`, request.NSFW, request.Spoiler, postID).Scan(&nsfw, &spoiler)
+	if err != nil {
+		log.Printf("Error updating post flags: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"nsfw":    nsfw,
+		"spoiler": spoiler,
+	})
+}
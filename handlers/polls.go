@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Post types. Text posts are the original kind; a poll post also has a row
+// in polls and its options in poll_options.
+const (
+	postTypeText = "text"
+	postTypePoll = "poll"
+)
+
+const (
+	minPollOptions     = 2
+	maxPollOptions     = 10
+	maxPollOptionLabel = 200
+)
+
+var errInvalidPoll = errors.New("a poll needs between 2 and 10 distinct options of up to 200 characters")
+
+// Poll is the poll attached to a poll post, as seen by one viewer. Vote
+// counts are only filled in when ResultsVisible is true.
+type Poll struct {
+	MultipleChoice bool         `json:"multiple_choice"`
+	ClosesAt       *time.Time   `json:"closes_at"`
+	Closed         bool         `json:"closed"`
+	HideResults    bool         `json:"hide_results"`
+	ResultsVisible bool         `json:"results_visible"`
+	TotalVoters    int          `json:"total_voters"`
+	Options        []PollOption `json:"options"`
+	HasVoted       bool         `json:"has_voted"`
+	MyChoices      []int64      `json:"my_choices"`
+}
+
+type PollOption struct {
+	ID    int64  `json:"id"`
+	Label string `json:"label"`
+	Votes int    `json:"votes"`
+}
+
+// newPollOptions trims the submitted option labels, drops empty ones and
+// checks the count, length and uniqueness limits.
+func newPollOptions(labels []string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var options []string
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		key := strings.ToLower(label)
+		if _, ok := seen[key]; ok || len([]rune(label)) > maxPollOptionLabel {
+			return nil, errInvalidPoll
+		}
+		seen[key] = struct{}{}
+		options = append(options, label)
+	}
+	if len(options) < minPollOptions || len(options) > maxPollOptions {
+		return nil, errInvalidPoll
+	}
+	return options, nil
+}
+
+// formBool reads a checkbox or boolean form value
+func formBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "on", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// insertPoll stores the poll and its options for a newly created post
+func insertPoll(tx *sql.Tx, postID int64, options []string, multiple, hideResults bool, closesAt *time.Time) error {
+	if _, err := tx.Exec(
+		"INSERT INTO polls (post_id, multiple_choice, hide_results, closes_at) VALUES (?, ?, ?, ?)",
+		postID, multiple, hideResults, closesAt); err != nil {
+		return err
+	}
+	for i, label := range options {
+		if _, err := tx.Exec("INSERT INTO poll_options (post_id, label, position) VALUES (?, ?, ?)", postID, label, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPolls attaches the poll of every poll post in posts, with the current
+// counts and viewerID's own ballot. viewerID may be empty for guests.
+func loadPolls(posts []Post, viewerID string) error {
+	index := make(map[int64]int)
+	var args []interface{}
+	for i, post := range posts {
+		if post.PostType == postTypePoll {
+			index[int64(post.ID)] = i
+			args = append(args, post.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	in := "(?" + strings.Repeat(",?", len(args)-1) + ")"
+	now := time.Now()
+
+	rows, err := db.Query(`
+		SELECT post_id, multiple_choice, hide_results, closes_at,
+			(SELECT COUNT(*) FROM poll_ballots b WHERE b.post_id = polls.post_id)
+		FROM polls
+		WHERE post_id IN `+in, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID int64
+		var closesAt sql.NullTime
+		poll := &Poll{Options: []PollOption{}, MyChoices: []int64{}}
+		if err := rows.Scan(&postID, &poll.MultipleChoice, &poll.HideResults, &closesAt, &poll.TotalVoters); err != nil {
+			rows.Close()
+			return err
+		}
+		if closesAt.Valid {
+			poll.ClosesAt = &closesAt.Time
+			poll.Closed = !now.Before(closesAt.Time)
+		}
+		poll.ResultsVisible = !poll.HideResults || poll.Closed
+		if !poll.ResultsVisible {
+			poll.TotalVoters = 0
+		}
+		posts[index[postID]].Poll = poll
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`
+		SELECT o.post_id, o.id, o.label, COUNT(c.option_id)
+		FROM poll_options o
+		LEFT JOIN poll_choices c ON c.option_id = o.id
+		WHERE o.post_id IN `+in+`
+		GROUP BY o.id
+		ORDER BY o.post_id, o.position, o.id`, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID int64
+		var option PollOption
+		if err := rows.Scan(&postID, &option.ID, &option.Label, &option.Votes); err != nil {
+			rows.Close()
+			return err
+		}
+		poll := posts[index[postID]].Poll
+		if poll == nil {
+			continue
+		}
+		if !poll.ResultsVisible {
+			option.Votes = 0
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if viewerID == "" {
+		return nil
+	}
+	rows, err = db.Query(`
+		SELECT post_id, option_id FROM poll_choices
+		WHERE user_id = ? AND post_id IN `+in+`
+		ORDER BY post_id, option_id`, append([]interface{}{viewerID}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID, optionID int64
+		if err := rows.Scan(&postID, &optionID); err != nil {
+			return err
+		}
+		if poll := posts[index[postID]].Poll; poll != nil {
+			poll.HasVoted = true
+			poll.MyChoices = append(poll.MyChoices, optionID)
+		}
+	}
+	return rows.Err()
+}
+
+// PollVoteHandler casts the current user's ballot on a poll. Each user gets
+// exactly one ballot per poll; the poll_ballots primary key rejects a second
+// one even if two requests race.
+func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to vote", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		PostID    int64   `json:"post_id"`
+		OptionIDs []int64 `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PostID <= 0 {
+		respondWithError(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	var multiple bool
+	var closesAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT pl.multiple_choice, pl.closes_at
+		FROM polls pl
+		JOIN posts p ON p.id = pl.post_id
+		WHERE pl.post_id = ? AND p.status = 'published'`, request.PostID).Scan(&multiple, &closesAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Poll not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading poll: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		respondWithError(w, "This poll is closed", http.StatusConflict)
+		return
+	}
+	if reason, err := lockReason(int(request.PostID)); errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Poll not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error checking post lock: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if reason != "" {
+		respondWithError(w, reason, http.StatusForbidden)
+		return
+	}
+
+	choices := make(map[int64]struct{})
+	for _, id := range request.OptionIDs {
+		choices[id] = struct{}{}
+	}
+	if len(choices) == 0 || (!multiple && len(choices) > 1) {
+		respondWithError(w, "Choose one option, or several on a multiple choice poll", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO poll_ballots (post_id, user_id, created_at) VALUES (?, ?, ?)", request.PostID, userID, time.Now()); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			respondWithError(w, "You have already voted in this poll", http.StatusConflict)
+			return
+		}
+		log.Printf("Error recording ballot: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for optionID := range choices {
+		// The option must belong to this poll; the SELECT inserts nothing otherwise
+		result, err := tx.Exec(`
+			INSERT INTO poll_choices (post_id, user_id, option_id)
+			SELECT post_id, ?, id FROM poll_options WHERE id = ? AND post_id = ?`,
+			userID, optionID, request.PostID)
+		if err != nil {
+			log.Printf("Error recording choice: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			respondWithError(w, "Invalid poll option", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	posts := []Post{{ID: int(request.PostID), PostType: postTypePoll}}
+	if err := loadPolls(posts, userID); err != nil {
+		log.Printf("Error loading poll results: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"poll":    posts[0].Poll,
+	})
+}
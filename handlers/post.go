@@ -2,11 +2,9 @@ package handlers
 
 import (
 	"database/sql"
-	"io"
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -39,63 +37,142 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if userID == "" {
+		RenderError(w, r, "Please log in to create a post", http.StatusUnauthorized)
+		return
+	}
 
 	// Handle POST request (create a new post)
 	title := strings.TrimSpace(r.FormValue("title"))
 	content := strings.TrimSpace(r.FormValue("content"))
 	categories := r.Form["category"] // Get multiple categories
 
-	// Validate input
-	if title == "" || content == "" || len(categories) == 0 {
-		RenderError(w, r, "Title, content, and at least one category are required", http.StatusBadRequest)
-		return
+	// status=draft saves without publishing; a future publish_at schedules
+	// the post for the scheduler to publish later.
+	status := postStatusPublished
+	if r.FormValue("status") == postStatusDraft {
+		status = postStatusDraft
 	}
-
-	// Handle image upload
-	var imagePath string
-	if r.MultipartForm != nil {
-		file, header, err := r.FormFile("image")
-		if err == nil {
-			defer file.Close()
-
-			// Check file size
-			if header.Size > maxImageSize {
-				RenderError(w, r, "Image size exceeds 20 MB limit", http.StatusBadRequest)
-				return
+	var publishAt *time.Time
+	if value := r.FormValue("publish_at"); value != "" {
+		t, err := parsePublishAt(value)
+		if err != nil {
+			RenderError(w, r, "Invalid publish time", http.StatusBadRequest)
+			return
+		}
+		if t.After(time.Now()) {
+			publishAt = &t
+			if status == postStatusPublished {
+				status = postStatusScheduled
 			}
+		}
+	}
 
-			// Validate image type
-			ext := strings.ToLower(filepath.Ext(header.Filename))
-			if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
-				RenderError(w, r, "Invalid image type. Only JPEG, PNG, and GIF are allowed.", http.StatusBadRequest)
+	// post_type=poll turns the post into a poll whose choices are the
+	// repeated poll_option fields
+	postType := postTypeText
+	var pollOptions []string
+	var pollClosesAt *time.Time
+	var linkURL string
+	switch r.FormValue("post_type") {
+	case "", postTypeText:
+	case postTypeLink:
+		postType = postTypeLink
+		linkURL, err = normalizeLinkURL(r.FormValue("link_url"))
+		if err != nil {
+			RenderError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reason, err := requireReputation(userID, KarmaToPostLinks, "post links")
+		if err != nil {
+			log.Printf("Error checking karma: %v", err)
+			RenderError(w, r, "Database Error", http.StatusInternalServerError)
+			return
+		} else if reason != "" {
+			RenderError(w, r, reason, http.StatusForbidden)
+			return
+		}
+	case postTypePoll:
+		postType = postTypePoll
+		pollOptions, err = newPollOptions(r.Form["poll_option"])
+		if err != nil {
+			RenderError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if value := r.FormValue("poll_closes_at"); value != "" {
+			t, err := parsePublishAt(value)
+			if err != nil || !t.After(time.Now()) {
+				RenderError(w, r, "Poll close time must be in the future", http.StatusBadRequest)
 				return
 			}
+			pollClosesAt = &t
+		}
+	default:
+		RenderError(w, r, "Invalid post type", http.StatusBadRequest)
+		return
+	}
 
-			// Ensure the uploads directory exists
-			if err := os.MkdirAll("uploads", os.ModePerm); err != nil {
-				RenderError(w, r, "Error creating uploads directory", http.StatusInternalServerError)
-				return
-			}
+	// Validate input. Drafts may be incomplete but not empty. A poll's
+	// question is its title and a link speaks for itself, so neither needs
+	// a body.
+	if status == postStatusDraft {
+		if title == "" && content == "" {
+			RenderError(w, r, "A draft needs a title or content", http.StatusBadRequest)
+			return
+		}
+	} else if title == "" || (content == "" && postType == postTypeText) || len(categories) == 0 {
+		RenderError(w, r, "Title, content, and at least one category are required", http.StatusBadRequest)
+		return
+	}
 
-			// Save the image to the uploads directory
-			imagePath = "uploads/" + header.Filename
-			out, err := os.Create(imagePath)
-			if err != nil {
-				log.Printf("Error saving image: %v", err)
-				RenderError(w, r, "Error saving image", http.StatusInternalServerError)
-				return
-			}
-			defer out.Close()
-			if _, err := io.Copy(out, file); err != nil {
-				log.Printf("Error writing image to file: %v", err)
-				RenderError(w, r, "Error writing image to file", http.StatusInternalServerError)
-				return
-			}
+	// Every category must be an existing community
+	if err := checkCommunities(categories); errors.Is(err, errUnknownCommunity) {
+		RenderError(w, r, "Unknown community", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error checking communities: %v", err)
+		RenderError(w, r, "Database Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Handle image uploads. "images" may be repeated; the older single
+	// "image" field is still accepted and goes first.
+	var attachments []Attachment
+	if r.MultipartForm != nil {
+		files := append(r.MultipartForm.File["image"], r.MultipartForm.File["images"]...)
+
+		// Validate, strip metadata and store under a content hash
+		attachments, err = storeAttachments(files, r.MultipartForm.Value["alt_text"])
+		if errors.Is(err, errTooManyAttachments) || errors.Is(err, errImageTooLarge) || errors.Is(err, errInvalidImage) || errors.Is(err, errImageDimensions) {
+			RenderError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error saving image: %v", err)
+			RenderError(w, r, "Error saving image", http.StatusInternalServerError)
+			return
 		}
 	}
+	// The stored images belong to nothing until the post is committed
+	committed := false
+	defer func() {
+		if !committed {
+			removeStoredAttachments(attachments)
+		}
+	}()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		RenderError(w, r, "Database Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
-	// Insert the new post into the database
-	result, err := db.Exec("INSERT INTO posts (user_id, title, content, image_path, created_at) VALUES (?, ?, ?, ?, ?)", userID, title, content, imagePath, time.Now())
+	// Insert the new post into the database. The users it mentions are
+	// notified once it is published.
+	contentHTML, _ := renderWithMentions(content)
+	result, err := tx.Exec("INSERT INTO posts (user_id, title, content, content_html, post_type, status, publish_at, is_nsfw, is_spoiler, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, title, content, contentHTML, postType, status, publishAt, formBool(r.FormValue("nsfw")), formBool(r.FormValue("spoiler")), time.Now())
 	if err != nil {
 		log.Printf("Error creating post: %v", err)
 		RenderError(w, r, "Error creating post", http.StatusInternalServerError)
@@ -111,21 +188,76 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert categories into the database
-	uniqueCategories := make(map[string]struct{})
-for _, c := range categories {
-	c = strings.TrimSpace(strings.ToLower(c)) // optional normalization
-	if c != "" {
-		uniqueCategories[c] = struct{}{}
-	}
-}
-	for category := range uniqueCategories {
-		_, err = db.Exec("INSERT INTO post_categories (post_id, category) VALUES (?, ?)", postID, category)
+	for _, category := range normalizeCategories(categories) {
+		_, err = tx.Exec("INSERT INTO post_categories (post_id, category) VALUES (?, ?)", postID, category)
 		if err != nil {
 			log.Printf("Error inserting category: %v", err)
+			RenderError(w, r, "Error creating post", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := insertAttachments(tx, postID, attachments); err != nil {
+		log.Printf("Error inserting attachments: %v", err)
+		RenderError(w, r, "Error creating post", http.StatusInternalServerError)
+		return
+	}
+
+	if postType == postTypePoll {
+		if err := insertPoll(tx, postID, pollOptions, formBool(r.FormValue("poll_multiple")), formBool(r.FormValue("poll_hide_results")), pollClosesAt); err != nil {
+			log.Printf("Error creating poll: %v", err)
+			RenderError(w, r, "Error creating post", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if postType == postTypeLink {
+		if _, err := tx.Exec("INSERT INTO link_previews (post_id, url) VALUES (?, ?)", postID, linkURL); err != nil {
+			log.Printf("Error creating link preview: %v", err)
+			RenderError(w, r, "Error creating post", http.StatusInternalServerError)
 			return
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing post: %v", err)
+		RenderError(w, r, "Error creating post", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+	if postType == postTypeLink {
+		queueLinkPreview()
+	}
+	if status == postStatusPublished {
+		onPostPublished(postID)
+	}
+
 	// Redirect to the posts page after successful creation
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// loadPostDetails fills in everything a feed shows beyond the posts row
+// itself: attachments, polls, link previews, content warnings, lock state
+// and what the viewer saved and voted.
+// viewerID personalizes the result and may be empty for guests.
+func loadPostDetails(posts []Post, viewerID string) error {
+	if err := loadAttachments(posts); err != nil {
+		return err
+	}
+	if err := loadPolls(posts, viewerID); err != nil {
+		return err
+	}
+	if err := loadLinkPreviews(posts); err != nil {
+		return err
+	}
+	if err := loadContentWarnings(posts, viewerID); err != nil {
+		return err
+	}
+	if err := loadPostState(posts); err != nil {
+		return err
+	}
+	if err := loadSaved(posts, viewerID); err != nil {
+		return err
+	}
+	return loadVotes(posts, viewerID)
+}
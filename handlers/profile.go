@@ -21,16 +21,18 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 			p.id, 
 			p.title, 
 			p.content, 
-			p.image_path,
+			p.content_html, p.post_type,
 			GROUP_CONCAT(DISTINCT pc.category) as categories, 
 			u.username, 
+			u.post_karma + u.comment_karma,
 			p.created_at,
-			(SELECT COUNT(*) FROM likes WHERE post_id = p.id AND is_like = 1) as like_count,
-			(SELECT COUNT(*) FROM likes WHERE post_id = p.id AND is_like = 0) as dislike_count
+			p.like_count,
+			p.dislike_count,
+			p.comment_count
 		FROM posts p 
 		JOIN users u ON p.user_id = u.id 
 		LEFT JOIN post_categories pc ON p.id = pc.post_id 
-		WHERE p.user_id = ?
+		WHERE p.user_id = ? AND p.status = 'published'
 		GROUP BY p.id 
 		ORDER BY p.created_at DESC`, userID)
 	if err != nil {
@@ -49,12 +51,15 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 			&post.ID,
 			&post.Title,
 			&post.Content,
-			&post.ImagePath,
+			&post.ContentHTML,
+			&post.PostType,
 			&categories,
 			&post.Username,
+			&post.AuthorKarma,
 			&createdAt,
 			&post.LikeCount,
 			&post.DislikeCount,
+			&post.CommentCount,
 		)
 		if err != nil {
 			log.Printf("Error scanning post: %v", err)
@@ -75,17 +80,19 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 			p.id, 
 			p.title, 
 			p.content,
-			p.image_path, 
+			p.content_html, p.post_type,
 			GROUP_CONCAT(DISTINCT pc.category) as categories, 
 			u.username, 
+			u.post_karma + u.comment_karma,
 			p.created_at,
-			(SELECT COUNT(*) FROM likes WHERE post_id = p.id AND is_like = 1) as like_count,
-			(SELECT COUNT(*) FROM likes WHERE post_id = p.id AND is_like = 0) as dislike_count
+			p.like_count,
+			p.dislike_count,
+			p.comment_count
 		FROM posts p 
 		JOIN users u ON p.user_id = u.id 
 		LEFT JOIN post_categories pc ON p.id = pc.post_id 
 		JOIN likes l ON p.id = l.post_id
-		WHERE l.user_id = ? AND l.is_like = 1
+		WHERE l.user_id = ? AND l.is_like = 1 AND p.status = 'published'
 		GROUP BY p.id 
 		ORDER BY p.created_at DESC`, userID)
 	if err != nil {
@@ -104,12 +111,15 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 			&post.ID,
 			&post.Title,
 			&post.Content,
-			&post.ImagePath,
+			&post.ContentHTML,
+			&post.PostType,
 			&categories,
 			&post.Username,
+			&post.AuthorKarma,
 			&createdAt,
 			&post.LikeCount,
 			&post.DislikeCount,
+			&post.CommentCount,
 		)
 		if err != nil {
 			log.Printf("Error scanning liked post: %v", err)
@@ -124,8 +134,23 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		userLikedPosts = append(userLikedPosts, post)
 	}
 
+	if err := loadPostDetails(userPosts, userID); err != nil {
+		log.Printf("Error fetching post details: %v", err)
+	}
+	if err := loadPostDetails(userLikedPosts, userID); err != nil {
+		log.Printf("Error fetching post details: %v", err)
+	}
+	mode, err := nsfwMode(userID)
+	if err != nil {
+		log.Printf("Error reading NSFW preference: %v", err)
+	}
+	userLikedPosts = withoutNSFW(userLikedPosts, mode)
+
 	// Get user information
 	var user User
+	var nsfwPreference string
+	var mentionNotifications bool
+	var postKarma, commentKarma int
 	err = db.QueryRow(`
     SELECT username, email,
            COALESCE(nickname, ''),
@@ -133,7 +158,10 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
            COALESCE(age, 0),
            COALESCE(gender, ''),
            COALESCE(first_name, ''),
-           COALESCE(last_name, '')
+           COALESCE(last_name, ''),
+           nsfw_preference,
+           mention_notifications,
+           post_karma, comment_karma
     FROM users WHERE id = ?`, userID).
 		Scan(
 			&user.Username,
@@ -144,6 +172,10 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 			&user.Gender,
 			&user.FirstName,
 			&user.LastName,
+			&nsfwPreference,
+			&mentionNotifications,
+			&postKarma,
+			&commentKarma,
 		)
 
 	if err != nil {
@@ -152,17 +184,31 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	followers, following, err := followCounts(userID)
+	if err != nil {
+		log.Printf("Error counting follows: %v", err)
+	}
+
 	data := map[string]any{
-		"Username":     user.Username,
-		"Email":        user.Email,
-		"Nickname":     user.Nickname,
-		"AvatarURL":    user.AvatarURL,
-		"Age":          user.Age,
-		"Gender":       user.Gender,
-		"FirstName":    user.FirstName,
-		"LastName":     user.LastName,
-		"CreatedPosts": userPosts,
-		"LikedPosts":   userLikedPosts,
+		"Username":       user.Username,
+		"Email":          user.Email,
+		"Nickname":       user.Nickname,
+		"AvatarURL":      user.AvatarURL,
+		"Age":            user.Age,
+		"Gender":         user.Gender,
+		"FirstName":      user.FirstName,
+		"LastName":       user.LastName,
+		"CreatedPosts":   userPosts,
+		"LikedPosts":     userLikedPosts,
+		"FollowerCount":  followers,
+		"FollowingCount": following,
+		"PostKarma":      postKarma,
+		"CommentKarma":   commentKarma,
+		"Karma":          postKarma + commentKarma,
+		"NSFWPreference": nsfwPreference,
+		"NSFWMode":       mode,
+
+		"MentionNotifications": mentionNotifications,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -205,20 +251,28 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
 		Gender    string `json:"gender"`
 		FirstName string `json:"first_name"`
 		LastName  string `json:"last_name"`
+		NSFW      string `json:"nsfw_preference"` // show, blur or hide
+		Mentions  *bool  `json:"mention_notifications"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	if payload.NSFW != "" && !validNSFWPreference(payload.NSFW) {
+		http.Error(w, "nsfw_preference must be show, blur or hide", http.StatusBadRequest)
+		return
+	}
 
 	query := `UPDATE users SET 
 		nickname = COALESCE(NULLIF(?, ''), nickname),
 		avatar_url = COALESCE(NULLIF(?, ''), avatar_url),
-		age = COALESCE(?, age),
+		age = COALESCE(NULLIF(?, 0), age),
 		gender = COALESCE(NULLIF(?, ''), gender),
 		first_name = COALESCE(NULLIF(?, ''), first_name),
-		last_name = COALESCE(NULLIF(?, ''), last_name)
+		last_name = COALESCE(NULLIF(?, ''), last_name),
+		nsfw_preference = COALESCE(NULLIF(?, ''), nsfw_preference),
+		mention_notifications = COALESCE(?, mention_notifications)
 		WHERE id = ?`
 
 	_, err := db.Exec(query,
@@ -228,6 +282,8 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
 		payload.Gender,
 		payload.FirstName,
 		payload.LastName,
+		payload.NSFW,
+		payload.Mentions,
 		userID,
 	)
 	if err != nil {
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxCollectionName = 50
+
+// Collection is a named group of a user's saved items
+type Collection struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Items     int       `json:"items"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavedItem is one entry in GET /api/saved: either a post or a comment,
+// with the post title for comments so the list can link back to the thread.
+type SavedItem struct {
+	Type         string    `json:"type"` // post or comment
+	CollectionID *int64    `json:"collection_id"`
+	SavedAt      time.Time `json:"saved_at"`
+	Post         *Post     `json:"post,omitempty"`
+	Comment      *Comment  `json:"comment,omitempty"`
+	PostTitle    string    `json:"post_title,omitempty"`
+}
+
+// loadSaved marks the posts, and every comment loaded with them, that
+// viewerID has saved. Guests have saved nothing.
+func loadSaved(posts []Post, viewerID string) error {
+	if viewerID == "" || len(posts) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	saved, err := savedIDs("post_id", viewerID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Saved = saved[posts[i].ID]
+		if err := markSavedComments(posts[i].Comments, viewerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markSavedComments sets Saved on the comments and their replies that
+// viewerID has saved.
+func markSavedComments(comments []Comment, viewerID string) error {
+	if viewerID == "" {
+		return nil
+	}
+	var ids []interface{}
+	var collect func([]Comment)
+	collect = func(comments []Comment) {
+		for _, c := range comments {
+			ids = append(ids, c.ID)
+			collect(c.Replies)
+		}
+	}
+	collect(comments)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	saved, err := savedIDs("comment_id", viewerID, ids)
+	if err != nil {
+		return err
+	}
+	var mark func([]Comment)
+	mark = func(comments []Comment) {
+		for i := range comments {
+			comments[i].Saved = saved[comments[i].ID]
+			mark(comments[i].Replies)
+		}
+	}
+	mark(comments)
+	return nil
+}
+
+// savedIDs returns which of ids the user has saved, where column is
+// post_id or comment_id.
+func savedIDs(column, userID string, ids []interface{}) (map[int]bool, error) {
+	rows, err := db.Query(`
+		SELECT `+column+` FROM saved_items
+		WHERE user_id = ? AND `+column+` IN (?`+strings.Repeat(",?", len(ids)-1)+`)`,
+		append([]interface{}{userID}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		saved[id] = true
+	}
+	return saved, rows.Err()
+}
+
+// userCollection checks that collectionID belongs to userID
+func userCollection(userID string, collectionID int64) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM collections WHERE id = ? AND user_id = ?)",
+		collectionID, userID).Scan(&exists)
+	return exists, err
+}
+
+// SavePostHandler saves post {id} on POST and unsaves it on DELETE
+func SavePostHandler(w http.ResponseWriter, r *http.Request) {
+	saveItem(w, r, "post_id", "SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND status = 'published')")
+}
+
+// SaveCommentHandler saves comment {id} on POST and unsaves it on DELETE
+func SaveCommentHandler(w http.ResponseWriter, r *http.Request) {
+	saveItem(w, r, "comment_id", `
+		SELECT EXISTS(SELECT 1 FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE c.id = ? AND p.status = 'published')`)
+}
+
+// saveItem implements both save endpoints. POST may file the item in one of
+// the user's collections with {"collection_id": n}; saving an item again
+// moves it to the given collection, or out of any with no body.
+func saveItem(w http.ResponseWriter, r *http.Request, column, existsQuery string) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to save items", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		respondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var request struct {
+			CollectionID *int64 `json:"collection_id"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				respondWithError(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var exists bool
+		if err := db.QueryRow(existsQuery, id).Scan(&exists); err != nil {
+			log.Printf("Error checking saved item: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		} else if !exists {
+			respondWithError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if request.CollectionID != nil {
+			owned, err := userCollection(userID, *request.CollectionID)
+			if err != nil {
+				log.Printf("Error checking collection: %v", err)
+				respondWithError(w, "Database error", http.StatusInternalServerError)
+				return
+			} else if !owned {
+				respondWithError(w, "Collection not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO saved_items (user_id, `+column+`, collection_id, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (user_id, `+column+`) DO UPDATE SET collection_id = excluded.collection_id`,
+			userID, id, request.CollectionID, time.Now())
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM saved_items WHERE user_id = ? AND "+column+" = ?", userID, id)
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating saved item: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"saved":   r.Method == http.MethodPost,
+	})
+}
+
+// CollectionsHandler lists the user's collections on GET and creates one
+// from {"name": ...} on POST.
+func CollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to manage collections", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+			SELECT c.id, c.name, c.created_at,
+				(SELECT COUNT(*) FROM saved_items WHERE collection_id = c.id)
+			FROM collections c
+			WHERE c.user_id = ?
+			ORDER BY c.name`, userID)
+		if err != nil {
+			log.Printf("Error fetching collections: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		collections := []Collection{}
+		for rows.Next() {
+			var c Collection
+			if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt, &c.Items); err != nil {
+				log.Printf("Error scanning collection: %v", err)
+				continue
+			}
+			collections = append(collections, c)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "collections": collections})
+
+	case http.MethodPost:
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(request.Name)
+		if name == "" || len([]rune(name)) > maxCollectionName {
+			respondWithError(w, "Collection names must be 1 to 50 characters", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		result, err := db.Exec("INSERT INTO collections (user_id, name, created_at) VALUES (?, ?, ?)", userID, name, now)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				respondWithError(w, "You already have a collection with that name", http.StatusConflict)
+				return
+			}
+			log.Printf("Error creating collection: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"collection": Collection{ID: id, Name: name, CreatedAt: now},
+		})
+
+	default:
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CollectionHandler deletes collection {id} on DELETE. Its items stay
+// saved, just no longer in a collection.
+func CollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to manage collections", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondWithError(w, "Invalid collection ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM collections WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		log.Printf("Error deleting collection: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondWithError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// SavedHandler lists the user's saved posts and comments, most recently
+// saved first. ?type=posts or ?type=comments narrows the list and
+// ?collection=id limits it to one collection.
+func SavedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := GetUserIdFromSession(w, r)
+	if userID == "" {
+		respondWithError(w, "Please log in to see saved items", http.StatusUnauthorized)
+		return
+	}
+	page, limit, offset := parsePagination(r, defaultFeedLimit, maxFeedLimit)
+
+	where := []string{"s.user_id = ?"}
+	args := []interface{}{userID}
+	switch r.URL.Query().Get("type") {
+	case "":
+	case "posts":
+		where = append(where, "s.post_id IS NOT NULL")
+	case "comments":
+		where = append(where, "s.comment_id IS NOT NULL")
+	default:
+		respondWithError(w, "type must be posts or comments", http.StatusBadRequest)
+		return
+	}
+	if value := r.URL.Query().Get("collection"); value != "" {
+		collectionID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			respondWithError(w, "Invalid collection ID", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "s.collection_id = ?")
+		args = append(args, collectionID)
+	}
+
+	// Saved posts, and comments on posts, that have since been unpublished
+	// are skipped
+	rows, err := db.Query(`
+		SELECT s.post_id, s.comment_id, s.collection_id, s.created_at
+		FROM saved_items s
+		LEFT JOIN comments c ON c.id = s.comment_id
+		JOIN posts p ON p.id = COALESCE(s.post_id, c.post_id)
+		WHERE `+strings.Join(where, " AND ")+` AND p.status = 'published'
+		ORDER BY s.created_at DESC, s.id DESC
+		LIMIT ? OFFSET ?`, append(args, limit+1, offset)...)
+	if err != nil {
+		log.Printf("Error fetching saved items: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type savedRow struct {
+		postID, commentID sql.NullInt64
+		item              SavedItem
+	}
+	var saved []savedRow
+	for rows.Next() {
+		var row savedRow
+		var collectionID sql.NullInt64
+		if err := rows.Scan(&row.postID, &row.commentID, &collectionID, &row.item.SavedAt); err != nil {
+			log.Printf("Error scanning saved item: %v", err)
+			respondWithError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if collectionID.Valid {
+			row.item.CollectionID = &collectionID.Int64
+		}
+		saved = append(saved, row)
+	}
+	rows.Close()
+	hasMore := len(saved) > limit
+	if hasMore {
+		saved = saved[:limit]
+	}
+
+	var postIDs, commentIDs []interface{}
+	for _, row := range saved {
+		if row.postID.Valid {
+			postIDs = append(postIDs, row.postID.Int64)
+		} else {
+			commentIDs = append(commentIDs, row.commentID.Int64)
+		}
+	}
+	posts, err := postsByID(postIDs, userID)
+	if err != nil {
+		log.Printf("Error fetching saved posts: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	comments, titles, err := savedComments(commentIDs, userID)
+	if err != nil {
+		log.Printf("Error fetching saved comments: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	items := []SavedItem{}
+	for _, row := range saved {
+		item := row.item
+		if row.postID.Valid {
+			post, ok := posts[int(row.postID.Int64)]
+			if !ok {
+				continue
+			}
+			item.Type, item.Post = "post", &post
+		} else {
+			comment, ok := comments[int(row.commentID.Int64)]
+			if !ok {
+				continue
+			}
+			item.Type, item.Comment, item.PostTitle = "comment", &comment, titles[comment.PostID]
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"items":    items,
+		"page":     page,
+		"limit":    limit,
+		"has_more": hasMore,
+	})
+}
+
+// savedComments loads the given comments without their replies, keyed by
+// ID, along with the titles of the posts they belong to. Comments on posts
+// viewerID may not see, as visiblePostCondition decides, are left out.
+func savedComments(ids []interface{}, viewerID string) (map[int]Comment, map[int]string, error) {
+	byID := make(map[int]Comment)
+	titles := make(map[int]string)
+	if len(ids) == 0 {
+		return byID, titles, nil
+	}
+	mode, err := nsfwMode(viewerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query(`
+		SELECT c.id, c.post_id, c.user_id, c.content, c.content_html, c.created_at, u.username, c.parent_id,
+			u.post_karma + u.comment_karma, c.edited_at, c.deleted_at IS NOT NULL,
+			c.comment_count, c.like_count, c.dislike_count,
+			p.title
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		JOIN posts p ON p.id = c.post_id
+		WHERE c.id IN (?`+strings.Repeat(",?", len(ids)-1)+`) AND `+visiblePostCondition,
+		append(ids, mode)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Comment
+		var title string
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.ContentHTML, &c.CreatedAt,
+			&c.Username, &c.ParentID, &c.AuthorKarma, &c.EditedAt, &c.Deleted, &c.ReplyCount, &c.LikeCount, &c.DislikeCount, &title); err != nil {
+			return nil, nil, err
+		}
+		c.CreatedAtHuman = TimeAgo(c.CreatedAt)
+		c.Saved = true
+		applyDeleted(&c)
+		byID[c.ID] = c
+		titles[c.PostID] = title
+	}
+	return byID, titles, rows.Err()
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// searchEnabled reports whether the FTS5 index could be created. SQLite only
+// ships the fts5 module when the binary is built with -tags sqlite_fts5.
+var searchEnabled bool
+
+// Markers handed to snippet() so matched terms can be wrapped in <mark> after
+// the surrounding user text has been HTML-escaped.
+const (
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+// SearchResult is a single post or comment hit returned by /api/search
+type SearchResult struct {
+	Type           string    `json:"type"`
+	PostID         int       `json:"post_id"`
+	CommentID      *int      `json:"comment_id,omitempty"`
+	Title          string    `json:"title"`
+	Snippet        string    `json:"snippet"`
+	Username       string    `json:"username"`
+	Categories     string    `json:"categories"`
+	CreatedAt      time.Time `json:"created_at"`
+	CreatedAtHuman string    `json:"created_at_human"`
+	NSFW           bool      `json:"nsfw"`
+	Spoiler        bool      `json:"spoiler"`
+	Saved          bool      `json:"saved"` // Whether the viewer saved the post or comment
+}
+
+// initSearch creates the FTS5 indexes for posts and comments along with the
+// triggers that keep them in sync, and backfills them the first time they are
+// created on an existing database.
+func initSearch() {
+	var existing int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('posts_fts', 'comments_fts')").Scan(&existing)
+	if err != nil {
+		log.Printf("Error checking search index: %v", err)
+		return
+	}
+
+	_, err = db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+		title, content,
+		content='posts', content_rowid='id',
+		tokenize='porter unicode61'
+	);
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+		content,
+		content='comments', content_rowid='id',
+		tokenize='porter unicode61'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END;
+	CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+	END;
+	CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+		INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+		INSERT INTO comments_fts(rowid, content) VALUES (new.id, new.content);
+	END;
+	CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END;
+	CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO comments_fts(rowid, content) VALUES (new.id, new.content);
+	END;
+	`)
+	if err != nil {
+		log.Printf("Full-text search disabled (build with -tags sqlite_fts5): %v", err)
+		return
+	}
+
+	if existing < 2 {
+		_, err = db.Exec(`
+			INSERT INTO posts_fts(posts_fts) VALUES ('rebuild');
+			INSERT INTO comments_fts(comments_fts) VALUES ('rebuild');
+		`)
+		if err != nil {
+			log.Printf("Error building search index: %v", err)
+			return
+		}
+	}
+
+	searchEnabled = true
+}
+
+// buildMatchQuery turns user input into an FTS5 MATCH expression. Text in
+// double quotes is searched as a phrase, a trailing * makes a word a prefix
+// match, and everything else is ANDed together. Every term is quoted so FTS5
+// operators typed by the user are treated as plain text.
+func buildMatchQuery(input string) string {
+	var terms []string
+	rest := strings.TrimSpace(input)
+	for rest != "" {
+		var term string
+		prefix := false
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				term, rest = rest[1:], ""
+			} else {
+				term, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '"' })
+			if end == -1 {
+				term, rest = rest, ""
+			} else {
+				term, rest = rest[:end], rest[end:]
+			}
+			if strings.HasSuffix(term, "*") {
+				term = strings.TrimRight(term, "*")
+				prefix = true
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		quoted := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			quoted += "*"
+		}
+		terms = append(terms, quoted)
+	}
+	return strings.Join(terms, " ")
+}
+
+// isMatchSyntaxError reports whether err is SQLite rejecting an FTS5 MATCH
+// expression rather than the database failing
+func isMatchSyntaxError(err error) bool {
+	message := err.Error()
+	return strings.HasPrefix(message, "fts5:") || message == "unterminated string" ||
+		strings.Contains(message, "malformed MATCH expression")
+}
+
+// highlightSnippet escapes a snippet produced by FTS5 and turns the match
+// markers into <mark> tags.
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetOpen, "<mark>")
+	return strings.ReplaceAll(escaped, snippetClose, "</mark>")
+}
+
+// SearchHandler serves GET /api/search. Supported query parameters:
+//   - q: search terms, "quoted phrases" and prefix* matches (required)
+//   - type: posts, comments or all (default all)
+//   - category, author: restrict to a category or a username/nickname
+//   - from, to: date range as YYYY-MM-DD or RFC 3339
+//   - sort: relevance (default) or recent
+//   - page, limit: pagination
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !searchEnabled {
+		respondWithError(w, "Search is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	viewerID := GetUserIdFromSession(w, r)
+	query := r.URL.Query()
+	match := buildMatchQuery(query.Get("q"))
+	if match == "" {
+		respondWithError(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	kind := query.Get("type")
+	if kind == "" {
+		kind = "all"
+	}
+	if kind != "all" && kind != "posts" && kind != "comments" {
+		respondWithError(w, "Invalid search type", http.StatusBadRequest)
+		return
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = "relevance"
+	}
+	if sort != "relevance" && sort != "recent" {
+		respondWithError(w, "Invalid sort order", http.StatusBadRequest)
+		return
+	}
+
+	// Filters shared by both halves of the search. Each half aliases the
+	// matched row's author as u and its post as p; {ts} is replaced with the
+	// matched row's own timestamp column.
+	var filters []string
+	var filterArgs []interface{}
+	if category := strings.TrimSpace(strings.ToLower(query.Get("category"))); category != "" {
+		filters = append(filters, "EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.id AND pc.category = ?)")
+		filterArgs = append(filterArgs, category)
+	}
+	if author := strings.TrimSpace(query.Get("author")); author != "" {
+		filters = append(filters, "(u.username = ? OR u.nickname = ?)")
+		filterArgs = append(filterArgs, author, author)
+	}
+	if from := query.Get("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			respondWithError(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, "julianday({ts}) >= julianday(?)")
+		filterArgs = append(filterArgs, t)
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			respondWithError(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, "julianday({ts}) <= julianday(?)")
+		filterArgs = append(filterArgs, t)
+	}
+	mode, err := nsfwMode(viewerID)
+	if err != nil {
+		log.Printf("Error reading NSFW preference: %v", err)
+		respondWithError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if mode == nsfwHide {
+		filters = append(filters, "p.is_nsfw = 0")
+	}
+
+	where := func(matchExpr, ts string) string {
+		clauses := []string{matchExpr}
+		for _, f := range filters {
+			clauses = append(clauses, strings.ReplaceAll(f, "{ts}", ts))
+		}
+		return strings.Join(clauses, " AND ")
+	}
+
+	var arms []string
+	var args []interface{}
+	if kind != "comments" {
+		arms = append(arms, `
+			SELECT 'post' AS type, p.id AS post_id, NULL AS comment_id, p.title,
+				snippet(posts_fts, -1, '`+snippetOpen+`', '`+snippetClose+`', '…', 24) AS snippet,
+				u.username, p.created_at AS created_at, bm25(posts_fts, 5.0, 1.0) AS rank
+			FROM posts_fts
+			JOIN posts p ON p.id = posts_fts.rowid
+			JOIN users u ON u.id = p.user_id
+			WHERE p.status = 'published' AND `+notHiddenCondition+` AND `+where("posts_fts MATCH ?", "p.created_at"))
+		args = append(args, viewerID, match)
+		args = append(args, filterArgs...)
+	}
+	if kind != "posts" {
+		arms = append(arms, `
+			SELECT 'comment' AS type, c.post_id AS post_id, c.id AS comment_id, p.title,
+				snippet(comments_fts, 0, '`+snippetOpen+`', '`+snippetClose+`', '…', 24) AS snippet,
+				u.username, c.created_at AS created_at, bm25(comments_fts) AS rank
+			FROM comments_fts
+			JOIN comments c ON c.id = comments_fts.rowid
+			JOIN posts p ON p.id = c.post_id
+			JOIN users u ON u.id = c.user_id
+			WHERE p.status = 'published' AND c.deleted_at IS NULL AND `+notHiddenCondition+` AND `+where("comments_fts MATCH ?", "c.created_at"))
+		args = append(args, viewerID, match)
+		args = append(args, filterArgs...)
+	}
+	union := strings.Join(arms, " UNION ALL ")
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ("+union+")", args...).Scan(&total); err != nil {
+		if isMatchSyntaxError(err) {
+			respondWithError(w, "Invalid search query", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error counting search results: %v", err)
+		respondWithError(w, "Error running search", http.StatusInternalServerError)
+		return
+	}
+
+	page, limit, offset := parsePagination(r, 20, 100)
+	order := "rank ASC, julianday(created_at) DESC"
+	if sort == "recent" {
+		order = "julianday(created_at) DESC"
+	}
+	rows, err := db.Query(`
+		SELECT s.type, s.post_id, s.comment_id, s.title, s.snippet, s.username, s.created_at,
+			COALESCE((SELECT GROUP_CONCAT(category) FROM post_categories WHERE post_id = s.post_id), ''),
+			(SELECT is_nsfw FROM posts WHERE id = s.post_id), (SELECT is_spoiler FROM posts WHERE id = s.post_id),
+			EXISTS(SELECT 1 FROM saved_items si WHERE si.user_id = ? AND
+				(si.comment_id = s.comment_id OR (s.comment_id IS NULL AND si.post_id = s.post_id)))
+		FROM (`+union+`) s
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?`, append(append([]interface{}{viewerID}, args...), limit, offset)...)
+	if err != nil {
+		log.Printf("Error running search: %v", err)
+		respondWithError(w, "Error running search", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var createdAt string
+		if err := rows.Scan(
+			&result.Type,
+			&result.PostID,
+			&result.CommentID,
+			&result.Title,
+			&result.Snippet,
+			&result.Username,
+			&createdAt,
+			&result.Categories,
+			&result.NSFW,
+			&result.Spoiler,
+			&result.Saved,
+		); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			continue
+		}
+		result.Snippet = highlightSnippet(result.Snippet)
+		result.CreatedAt = parseSQLiteTime(createdAt)
+		result.CreatedAtHuman = TimeAgo(result.CreatedAt)
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":  results,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+		"has_more": offset+len(results) < total,
+	})
+}
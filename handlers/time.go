@@ -39,3 +39,26 @@ func TimeAgo(createdAt time.Time) string {
 		return fmt.Sprintf("%d days ago", days)
 	}
 }
+
+// sqliteTimeFormats are the layouts SQLite and the sqlite3 driver use when a
+// timestamp comes back as text, e.g. from a UNION or an aggregate.
+var sqliteTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseSQLiteTime parses a timestamp read from SQLite as text. It returns the
+// zero time if none of the known layouts match.
+func parseSQLiteTime(value string) time.Time {
+	for _, layout := range sqliteTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	uploadsDir     = "uploads"
+	thumbnailsDir  = "uploads/thumbs"
+	thumbnailSize  = 640
+	// maxImagePixels guards against decompression bombs: a tiny file that
+	// claims enormous dimensions.
+	maxImagePixels = 40 * 1000 * 1000
+	// maxGIFFrames caps animations of frames so small that the pixel budget
+	// alone would allow millions of them.
+	maxGIFFrames   = 1000
+)
+
+var (
+	errImageTooLarge   = errors.New("image size exceeds 20 MB limit")
+	errInvalidImage    = errors.New("invalid image type. Only JPEG, PNG, and GIF are allowed")
+	errImageDimensions = errors.New("image dimensions are too large")
+)
+
+// StoredImage describes an uploaded image after it has been sanitized and
+// written to disk.
+type StoredImage struct {
+	Path          string
+	ThumbnailPath string
+	Width         int
+	Height        int
+}
+
+// sniffImageType identifies an image by its magic bytes rather than trusting
+// the file name or the Content-Type sent by the browser.
+func sniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	}
+	return ""
+}
+
+// saveUploadedImage validates an uploaded image, re-encodes it to drop EXIF,
+// GPS and any other embedded metadata, and stores it under a name derived
+// from its content so identical uploads share one file. A downscaled
+// thumbnail is written alongside it for use in feeds.
+func saveUploadedImage(file io.Reader) (StoredImage, error) {
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		return StoredImage{}, err
+	}
+	if len(data) > maxImageSize {
+		return StoredImage{}, errImageTooLarge
+	}
+
+	format := sniffImageType(data)
+	if format == "" {
+		return StoredImage{}, errInvalidImage
+	}
+
+	config, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decodedFormat != format {
+		return StoredImage{}, errInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return StoredImage{}, errImageDimensions
+	}
+
+	// Decoding and re-encoding from pixels is what strips the metadata
+	var clean bytes.Buffer
+	var first image.Image
+	ext := "." + format
+	switch format {
+	case "gif":
+		// Count the frames before decoding, since DecodeAll allocates every
+		// one of them up front
+		maxFrames := min(maxImagePixels * 4 / (config.Width * config.Height), maxGIFFrames)
+		frames, ok := gifFrameCount(data, maxFrames)
+		if !ok {
+			return StoredImage{}, errInvalidImage
+		}
+		if frames > maxFrames {
+			return StoredImage{}, errImageDimensions
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return StoredImage{}, errInvalidImage
+		}
+		if err := gif.EncodeAll(&clean, anim); err != nil {
+			return StoredImage{}, err
+		}
+		first = anim.Image[0]
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return StoredImage{}, errInvalidImage
+		}
+		if err := png.Encode(&clean, img); err != nil {
+			return StoredImage{}, err
+		}
+		first = img
+	case "jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return StoredImage{}, errInvalidImage
+		}
+		// The orientation lives in the EXIF data we're about to drop, so
+		// bake it into the pixels first.
+		img = applyOrientation(img, jpegOrientation(data))
+		if err := jpeg.Encode(&clean, img, &jpeg.Options{Quality: 90}); err != nil {
+			return StoredImage{}, err
+		}
+		first = img
+		ext = ".jpg"
+	}
+
+	sum := sha256.Sum256(clean.Bytes())
+	name := hex.EncodeToString(sum[:])
+	stored := StoredImage{
+		Path:   filepath.ToSlash(filepath.Join(uploadsDir, name+ext)),
+		Width:  first.Bounds().Dx(),
+		Height: first.Bounds().Dy(),
+	}
+
+	if err := os.MkdirAll(thumbnailsDir, os.ModePerm); err != nil {
+		return StoredImage{}, err
+	}
+	if err := writeFileOnce(stored.Path, clean.Bytes()); err != nil {
+		return StoredImage{}, err
+	}
+
+	// JPEG thumbnails stay JPEG; PNG and GIF ones use PNG to keep transparency
+	thumbExt := ".png"
+	if format == "jpeg" {
+		thumbExt = ".jpg"
+	}
+	stored.ThumbnailPath = filepath.ToSlash(filepath.Join(thumbnailsDir, name+thumbExt))
+	if _, err := os.Stat(stored.ThumbnailPath); err == nil {
+		return stored, nil
+	}
+
+	var thumb bytes.Buffer
+	small := resizeToFit(first, thumbnailSize)
+	if format == "jpeg" {
+		err = jpeg.Encode(&thumb, small, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&thumb, small)
+	}
+	if err != nil {
+		return StoredImage{}, err
+	}
+	if err := writeFileOnce(stored.ThumbnailPath, thumb.Bytes()); err != nil {
+		return StoredImage{}, err
+	}
+	return stored, nil
+}
+
+// writeFileOnce writes data to path unless the file already exists. Because
+// names are content hashes an existing file already holds the same bytes. The
+// data is written to a temporary file first so readers never see a partial
+// image.
+func writeFileOnce(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// gifFrameCount counts the frames in a GIF by walking its blocks without
+// decoding any of them, stopping once there are more than limit. ok is false
+// when the blocks aren't laid out as a GIF's should be.
+func gifFrameCount(data []byte, limit int) (frames int, ok bool) {
+	if len(data) < 13 {
+		return 0, false
+	}
+	i := 13 // header and logical screen descriptor
+	if data[10]&0x80 != 0 { // global color table
+		i += 3 << (data[10]&7 + 1)
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: introducer and label, then data sub-blocks
+			i += 2
+		case 0x2C: // image descriptor, local color table and LZW code size
+			if i+10 > len(data) {
+				return frames, false
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+			i++
+			frames++
+			if frames > limit {
+				return frames, true
+			}
+		case 0x3B: // trailer
+			return frames, true
+		default:
+			return frames, false
+		}
+		// Data sub-blocks, ended by an empty one
+		for {
+			if i >= len(data) {
+				return frames, false
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	// Decoders accept a file that ends without its trailer
+	return frames, true
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 when the
+// file has none or its headers are malformed.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+2 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // fill byte before a marker
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // TEM and RSTn have no length
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: // image data starts, no more headers
+			return 1
+		}
+		if i+4 > len(data) {
+			return 1
+		}
+		// The length counts its own two bytes
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 {
+			return 1
+		}
+		segment := data[i+4 : min(i+2+length, len(data))]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for e := 0; e < entries; e++ {
+		entry := offset + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and flips img so that it displays upright for the
+// given EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// resizeToFit scales img down so neither side exceeds size, keeping its
+// aspect ratio and averaging the source pixels each output pixel covers.
+// Images that already fit are returned unchanged.
+func resizeToFit(img image.Image, size int) image.Image {
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return img
+	}
+	width, height := size, max(1, sh*size/sw)
+	if sh > sw {
+		width, height = max(1, sw*size/sh), size
+	}
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := y * sh / height
+		sy1 := max((y+1)*sh/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := x * sw / width
+			sx1 := max((x+1)*sw/width, sx0+1)
+
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			di := dst.PixOffset(x, y)
+			dst.Pix[di] = uint8(r / n)
+			dst.Pix[di+1] = uint8(g / n)
+			dst.Pix[di+2] = uint8(b / n)
+			dst.Pix[di+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
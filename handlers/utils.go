@@ -4,6 +4,8 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ErrorData represents the data passed to the error template
@@ -139,3 +141,38 @@ func renderError(w http.ResponseWriter, r *http.Request, errorKey string, status
 
 // RenderError is a function variable that can be mocked in tests
 var RenderError RenderErrorFunc = renderError
+
+// parsePagination reads the page and limit query parameters. Missing or
+// invalid values fall back to page 1 and defaultLimit, and the limit is
+// capped at maxLimit.
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (page, limit, offset int) {
+	page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return page, limit, (page - 1) * limit
+}
+
+// parseDateParam parses a date query parameter given either as YYYY-MM-DD or
+// RFC 3339. When endOfDay is set, plain dates are moved to the end of that day
+// so that "to=2024-01-31" includes the whole of the 31st.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// What a user's vote on a post or comment can be
+const (
+	voteUp   = "up"
+	voteDown = "down"
+	voteNone = "none"
+)
+
+// voteTarget describes what can be voted on: the table votes go in, the
+// column naming what they are on, the table keeping its counters and a query
+// returning the lock state of a votable row, with no row if it can't be
+// voted on.
+type voteTarget struct {
+	votes, column, counted, conflict, lockQuery string
+}
+
+var (
+	postVotes = voteTarget{"likes", "post_id", "posts", "post_id, user_id",
+		"SELECT locked, archived FROM posts WHERE id = ? AND status = 'published'"}
+	commentVotes = voteTarget{"comment_likes", "comment_id", "comments", "user_id, comment_id", `
+		SELECT p.locked, p.archived FROM comments c
+		JOIN posts p ON p.id = c.post_id
+		WHERE c.id = ? AND c.deleted_at IS NULL`}
+)
+
+// voteResult is a post's or comment's counters after a vote, and the vote
+type voteResult struct {
+	LikeCount, DislikeCount, Score int
+	Vote                           string
+}
+
+// parseVote reads the vote form value: up, down or none. Older clients send
+// is_like instead, which sets an up or down vote.
+func parseVote(r *http.Request) (string, bool) {
+	switch vote := r.FormValue("vote"); vote {
+	case voteUp, voteDown, voteNone:
+		return vote, true
+	case "":
+		isLike, err := strconv.ParseBool(r.FormValue("is_like"))
+		if err != nil {
+			return "", false
+		}
+		return voteName(&isLike), true
+	}
+	return "", false
+}
+
+// setVote makes userID's vote on row id of target be vote, whatever it was
+// before, so repeating a vote changes nothing. It returns sql.ErrNoRows if
+// the row can't be voted on and a reason if its post is closed to votes.
+func setVote(target voteTarget, id int, userID, vote string) (voteResult, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return voteResult{}, "", err
+	}
+	defer tx.Rollback()
+
+	if reason, err := scanLockReason(tx.QueryRow(target.lockQuery, id)); err != nil || reason != "" {
+		return voteResult{}, reason, err
+	}
+
+	if vote == voteNone {
+		_, err = tx.Exec("DELETE FROM "+target.votes+" WHERE "+target.column+" = ? AND user_id = ?", id, userID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO `+target.votes+` (`+target.column+`, user_id, is_like) VALUES (?, ?, ?)
+			ON CONFLICT(`+target.conflict+`) DO UPDATE SET is_like = excluded.is_like
+			WHERE is_like != excluded.is_like`, id, userID, vote == voteUp)
+	}
+	if err != nil {
+		return voteResult{}, "", err
+	}
+
+	result := voteResult{Vote: vote}
+	if err := tx.QueryRow("SELECT like_count, dislike_count, score FROM "+target.counted+" WHERE id = ?", id).
+		Scan(&result.LikeCount, &result.DislikeCount, &result.Score); err != nil {
+		return voteResult{}, "", err
+	}
+	return result, "", tx.Commit()
+}
+
+// voteName names the vote stored as is_like, nil meaning there is none
+func voteName(isLike *bool) string {
+	switch {
+	case isLike == nil:
+		return voteNone
+	case *isLike:
+		return voteUp
+	}
+	return voteDown
+}
+
+// loadVotes sets UserVote on the posts, and every comment loaded with them,
+// to viewerID's vote. Guests have voted on nothing.
+func loadVotes(posts []Post, viewerID string) error {
+	var votes map[int]bool
+	if viewerID != "" && len(posts) > 0 {
+		ids := make([]interface{}, len(posts))
+		for i, post := range posts {
+			ids[i] = post.ID
+		}
+		var err error
+		if votes, err = viewerVotes("likes", "post_id", viewerID, ids); err != nil {
+			return err
+		}
+	}
+	for i := range posts {
+		posts[i].UserVote = voteNone
+		if isLike, ok := votes[posts[i].ID]; ok {
+			posts[i].UserVote = voteName(&isLike)
+		}
+		if err := markCommentVotes(posts[i].Comments, viewerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markCommentVotes sets UserVote and UserLiked on the comments and their
+// replies to viewerID's vote.
+func markCommentVotes(comments []Comment, viewerID string) error {
+	var ids []interface{}
+	var collect func([]Comment)
+	collect = func(comments []Comment) {
+		for _, c := range comments {
+			ids = append(ids, c.ID)
+			collect(c.Replies)
+		}
+	}
+	collect(comments)
+
+	var votes map[int]bool
+	if viewerID != "" && len(ids) > 0 {
+		var err error
+		if votes, err = viewerVotes("comment_likes", "comment_id", viewerID, ids); err != nil {
+			return err
+		}
+	}
+	var mark func([]Comment)
+	mark = func(comments []Comment) {
+		for i := range comments {
+			comments[i].UserLiked = nil
+			if isLike, ok := votes[comments[i].ID]; ok {
+				comments[i].UserLiked = &isLike
+			}
+			comments[i].UserVote = voteName(comments[i].UserLiked)
+			mark(comments[i].Replies)
+		}
+	}
+	mark(comments)
+	return nil
+}
+
+// viewerVotes returns the user's votes on ids as is_like, read from table,
+// which is likes or comment_likes, where column is post_id or comment_id.
+// IDs are bound in chunks of commentStatsChunk.
+func viewerVotes(table, column, userID string, ids []interface{}) (map[int]bool, error) {
+	votes := make(map[int]bool)
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), commentStatsChunk)]
+		ids = ids[len(chunk):]
+		rows, err := db.Query(`
+			SELECT `+column+`, is_like FROM `+table+`
+			WHERE user_id = ? AND `+column+` IN (?`+strings.Repeat(",?", len(chunk)-1)+`)`,
+			append([]interface{}{userID}, chunk...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var isLike bool
+			if err := rows.Scan(&id, &isLike); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			votes[id] = isLike
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return votes, nil
+}
@@ -11,8 +11,12 @@ import (
 
 func main() {
 	args := os.Args
+	if len(args) == 2 && args[1] == "repair-counters" {
+		repairCounters()
+		return
+	}
 	if len(args) != 1 {
-		fmt.Println("usage: go run .")
+		fmt.Println("usage: go run . [repair-counters]")
 		return
 	}
 	// Serve static files from the "static" directory
@@ -42,10 +46,44 @@ func main() {
 	http.HandleFunc("/api/chat/messages", handlers.ChatMessagesHandler)
 	http.HandleFunc("/api/profile/update", handlers.UpdateProfileHandler)
 	http.HandleFunc("/api/comment/like", handlers.CommentLikeHandler)
+	http.HandleFunc("/api/search", handlers.SearchHandler)
+	http.HandleFunc("/api/preview", handlers.PreviewHandler)
+	http.HandleFunc("/api/drafts", handlers.DraftsHandler)
+	http.HandleFunc("/api/drafts/autosave", handlers.AutosaveDraftHandler)
+	http.HandleFunc("/api/drafts/publish", handlers.PublishDraftHandler)
+	http.HandleFunc("/api/polls/vote", handlers.PollVoteHandler)
+	http.HandleFunc("/api/communities", handlers.CommunitiesHandler)
+	http.HandleFunc("/api/communities/{name}", handlers.CommunityHandler)
+	http.HandleFunc("/api/communities/{name}/subscribe", handlers.CommunitySubscriptionHandler)
+	http.HandleFunc("/api/communities/{name}/moderators", handlers.CommunityModeratorsHandler)
+	http.HandleFunc("GET /api/users/{username}", handlers.UserProfileHandler)
+	http.HandleFunc("/api/users/{username}/follow", handlers.FollowHandler)
+	http.HandleFunc("GET /api/users/{username}/followers", handlers.FollowersHandler)
+	http.HandleFunc("GET /api/users/{username}/following", handlers.FollowingHandler)
+	http.HandleFunc("/api/users/{username}/block", handlers.BlockHandler)
+	http.HandleFunc("/api/reputation", handlers.ReputationHandler)
+	http.HandleFunc("/api/feed/following", handlers.FollowingFeedHandler)
+	http.HandleFunc("/api/notifications", handlers.NotificationsHandler)
+	http.HandleFunc("/api/notifications/read", handlers.MarkNotificationsReadHandler)
+	http.HandleFunc("/api/posts/{id}/save", handlers.SavePostHandler)
+	http.HandleFunc("/api/comments/{id}/save", handlers.SaveCommentHandler)
+	http.HandleFunc("GET /api/comments/{id}", handlers.CommentThreadHandler)
+	http.HandleFunc("/api/comments/{id}", handlers.CommentItemHandler)
+	http.HandleFunc("GET /api/comments/{id}/revisions", handlers.CommentRevisionsHandler)
+	http.HandleFunc("/api/collections", handlers.CollectionsHandler)
+	http.HandleFunc("/api/collections/{id}", handlers.CollectionHandler)
+	http.HandleFunc("/api/saved", handlers.SavedHandler)
+	http.HandleFunc("/api/posts/{id}/hide", handlers.HidePostHandler)
+	http.HandleFunc("/api/hidden", handlers.HiddenPostsHandler)
+	http.HandleFunc("/api/posts/{id}/flags", handlers.PostFlagsHandler)
+	http.HandleFunc("/api/posts/{id}/pin", handlers.PinPostHandler)
+	http.HandleFunc("/api/posts/{id}/lock", handlers.LockPostHandler)
 
 	// Initialize the database
 	handlers.InitDB()
 	go handlers.StartChatManager()
+	go handlers.StartScheduler()
+	go handlers.StartLinkPreviewWorker()
 
 	// Start the server
 	log.Println("Server is running on http://localhost:8080")
@@ -54,3 +92,18 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// repairCounters recomputes the vote and comment counters on posts and
+// comments, and users' karma, from the votes and comments themselves
+func repairCounters() {
+	handlers.InitDB()
+	posts, comments, err := handlers.RepairCounters()
+	if err != nil {
+		log.Fatal("Error repairing counters: ", err)
+	}
+	users, err := handlers.RepairKarma()
+	if err != nil {
+		log.Fatal("Error repairing karma: ", err)
+	}
+	fmt.Printf("Repaired counters on %d posts and %d comments and karma of %d users\n", posts, comments, users)
+}
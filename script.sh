@@ -47,4 +47,4 @@ export GITHUB_CLIENT_SECRET
 # echo "Docker container is running on port $PORT with Google OAuth configured." || \
 # { echo "Failed to run Docker container."; exit 1; }
 
-go run main.go
\ No newline at end of file
+go run -tags sqlite_fts5 main.go
\ No newline at end of file
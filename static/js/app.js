@@ -26,6 +26,7 @@ async function render(path) {
     const app = document.getElementById('app');
     const authButtons = document.getElementById('auth-buttons');
     const isLoggedIn = await checkLoginStatus();
+    await loadCommunities();
 
     // if (path !== '/login' && path !== '/register' && !isLoggedIn) {
     //     window.location.hash = '/login';
@@ -42,6 +43,10 @@ async function render(path) {
                 return;
             }
             app.innerHTML = await fetchFilteredContent(category);
+        } else if (path.startsWith('/comments/')) {
+            app.innerHTML = await fetchCommentThread(path.slice('/comments/'.length));
+        } else if (path.startsWith('/users/')) {
+            app.innerHTML = await fetchUserProfile(decodeURIComponent(path.slice('/users/'.length)));
         } else {
             switch (path) {
                 case '/':
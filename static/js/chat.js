@@ -98,6 +98,7 @@ function renderChatUsers(users = []) {
             </div>
             <div class="user-info">
                 <span class="username">${user.username}</span>
+                <span class="karma">${user.karma || 0} karma</span>
                 <div class="user-status">
                     <span class="last-message">${user.last_message ? 
                         user.last_message.substring(0,30) : 'No messages yet'}</span>
@@ -273,17 +274,33 @@ function createMessageElement(msg) {
                     ${avatarContent}
                     <div class="message-metadata">
                         <span class="sender">${username}</span>
+                        ${isCurrentUser ? '' : `<span class="karma">${msg.sender_karma || 0} karma</span>`}
                     </div>
                 </div>
             ` : ''}
             <div class="message-content">
-                ${msg.content}
+                ${renderMessageContent(msg)}
                 <span class="time">${messageTime}</span>
             </div>
         </div>
     `;
 }
 
+// Escape a message's text and link the mentions the server resolved
+function renderMessageContent(msg) {
+    const linked = {};
+    (msg.mentions || []).forEach(m => {
+        linked[m.username.toLowerCase()] = m;
+        if (m.nickname) linked[m.nickname.toLowerCase()] = m;
+    });
+    return escapeHTML(msg.content).replace(/(^|[^\p{L}\p{N}_@.\-])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]*)/gu, (match, before, name) => {
+        const trimmed = name.replace(/[.\-]+$/, '');
+        const m = linked[trimmed.toLowerCase()];
+        if (!m) return match;
+        return `${before}<a href="${escapeHTML(m.profile_url)}" class="mention">@${trimmed}</a>${name.slice(trimmed.length)}`;
+    });
+}
+
 // Format message time
 function formatMessageTime(date) {
     const now = new Date();
@@ -356,6 +373,14 @@ function sendMessage() {
 function handleWebSocketMessage(data) {
     console.log('Received WebSocket message:', data);
 
+    // The server refused a message we sent; take back its placeholder
+    if (data.type === 'message_error') {
+        document.querySelector(`[data-message-id="${data.temp_id}"]`)?.remove();
+        renderedMessages.delete(data.temp_id);
+        alert(data.error);
+        return;
+    }
+
     const messageId = data.temp_id || data.id;
 
     // Early return if message already exists
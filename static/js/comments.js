@@ -14,12 +14,25 @@ function toggleCommentForm(postId) {
     }
 }
 
+// Comment sort chosen per post; missing means the post's default
+const commentSortByPost = {};
+
+function commentSortParam(postId) {
+    const sort = commentSortByPost[postId];
+    return sort ? `&sort=${encodeURIComponent(sort)}` : '';
+}
+
+function changeCommentSort(postId, sort) {
+    commentSortByPost[postId] = sort;
+    loadComments(postId);
+}
+
 async function loadComments(postId) {
     const commentsSection = document.getElementById(`comments-${postId}`);
     if (!commentsSection) return;
 
     try {
-        const response = await fetch(`/api/comments?post_id=${postId}`);
+        const response = await fetch(`/api/comments?post_id=${postId}${commentSortParam(postId)}`);
         
         // First check if the response is successful
         if (!response.ok) {
@@ -30,14 +43,14 @@ async function loadComments(postId) {
         const data = await response.json();
         
         // Handle empty comments array
-        if (!data || !Array.isArray(data)) {
+        if (!data || !Array.isArray(data.comments)) {
             commentsSection.innerHTML = '<p class="no-comments">No comments yet. Be the first to comment!</p>';
             return;
         }
 
         // If we get here, render the comments
-        commentsSection.innerHTML = data.length > 0 
-            ? renderComments(data) 
+        commentsSection.innerHTML = data.comments.length > 0 
+            ? renderComments(data.comments) + loadMoreButton(postId, null, data)
             : '<p class="no-comments">No comments yet. Be the first to comment!</p>';
 
     } catch (error) {
@@ -96,27 +109,170 @@ async function handleCommentSubmit(postId, event) {
     return false;
 }
 
-function renderComments(comments) {
+// focusId highlights one comment, the target of a permalink
+function renderComments(comments, focusId) {
     if (!comments || comments.length === 0) {
         return '<p class="no-comments">No comments yet. Be the first to comment!</p>';
     }
 
     return comments.map(comment => `
-        <div class="comment" data-comment-id="${comment.ID}">
+        <div class="comment ${comment.ID === focusId ? 'comment-focused' : ''}" data-comment-id="${comment.ID}">
             <div class="comment-header">
                 <span class="comment-author">${comment.Username || 'Anonymous'}</span>
+                ${comment.Deleted ? '' : `<span class="karma">${comment.AuthorKarma || 0} karma</span>`}
                 <span class="comment-time">${comment.CreatedAtHuman || formatDate(comment.CreatedAt) || 'Just now'}</span>
+                ${comment.EditedAt ? `<span class="comment-edited" title="Edited ${formatDate(comment.EditedAt)}">(edited)</span>` : ''}
             </div>
-            <div class="comment-content">${comment.Content || ''}</div>
-            ${comment.Replies && comment.Replies.length > 0 ? `
+            <div class="comment-content">${comment.ContentHTML || comment.Content || ''}</div>
+            ${comment.CanEdit || comment.CanDelete ? `
+                <div class="comment-actions">
+                    ${comment.CanEdit ? `
+                        <textarea class="comment-edit" style="display:none;">${escapeHTML(comment.Content)}</textarea>
+                        <button class="reply-button" onclick="editComment(${comment.ID}, this)">Edit</button>
+                    ` : ''}
+                    ${comment.CanDelete ? `
+                        <button class="reply-button" onclick="deleteComment(${comment.PostID}, ${comment.ID})">Delete</button>
+                    ` : ''}
+                </div>
+            ` : ''}
+            ${(comment.Replies && comment.Replies.length > 0) || comment.MoreReplies > 0 ? `
                 <div class="replies">
-                    ${renderComments(comment.Replies)}
+                    ${comment.Replies ? renderComments(comment.Replies, focusId) : ''}
+                    ${comment.MoreReplies > 0 ? `
+                        <button class="load-more" onclick="loadMoreComments(${comment.PostID}, ${comment.ID}, '${comment.RepliesCursor}', this)">
+                            Load ${comment.MoreReplies} more ${comment.MoreReplies === 1 ? 'reply' : 'replies'}
+                        </button>
+                    ` : ''}
                 </div>
             ` : ''}
+            ${comment.ContinueThread ? `
+                <button class="continue-thread" onclick="continueThread(${comment.PostID}, ${comment.ID}, this)">
+                    Continue this thread (${comment.ReplyCount} ${comment.ReplyCount === 1 ? 'reply' : 'replies'})
+                </button>
+            ` : ''}
         </div>
     `).join('');
 }
 
+// Button for the page after data, of top-level comments or, with parentId,
+// of the replies to that comment
+function loadMoreButton(postId, parentId, data) {
+    if (!data.has_more) return '';
+    return `
+        <button class="load-more" onclick="loadMoreComments(${postId}, ${parentId}, '${data.next_cursor}', this)">
+            ${parentId ? 'Load more replies' : 'Load more comments'}
+        </button>
+    `;
+}
+
+async function fetchCommentPage(postId, parentId, cursor) {
+    let url = `/api/comments?post_id=${postId}${commentSortParam(postId)}`;
+    if (parentId) url += `&parent_id=${parentId}`;
+    if (cursor) url += `&cursor=${encodeURIComponent(cursor)}`;
+    const response = await fetch(url);
+    if (!response.ok) {
+        throw new Error(`Server returned ${response.status}`);
+    }
+    return response.json();
+}
+
+// Replaces the button with the next page of comments and, if there are
+// more still, a button for the page after it
+async function loadMoreComments(postId, parentId, cursor, button) {
+    button.disabled = true;
+    try {
+        const data = await fetchCommentPage(postId, parentId, cursor);
+        button.outerHTML = renderComments(data.comments) + loadMoreButton(postId, parentId, data);
+    } catch (error) {
+        console.error('Comment page load error:', error);
+        button.disabled = false;
+    }
+}
+
+async function continueThread(postId, commentId, button) {
+    button.disabled = true;
+    try {
+        const data = await fetchCommentPage(postId, commentId, '');
+        button.outerHTML = `<div class="replies">${renderComments(data.comments)}${loadMoreButton(postId, commentId, data)}</div>`;
+    } catch (error) {
+        console.error('Thread load error:', error);
+        button.disabled = false;
+    }
+}
+
+// Toggles the comment between its text and an editor; saving sends the edit
+async function editComment(commentId, button) {
+    const comment = button.closest('.comment');
+    const editor = comment.querySelector(':scope > .comment-actions > .comment-edit');
+    const content = comment.querySelector(':scope > .comment-content');
+
+    if (editor.style.display === 'none') {
+        editor.style.display = 'block';
+        content.style.display = 'none';
+        button.textContent = 'Save';
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/comments/${commentId}`, {
+            method: 'PUT',
+            credentials: 'include',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ content: editor.value })
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Failed to edit comment');
+        }
+        content.innerHTML = data.content_html;
+        if (data.edited_at && !comment.querySelector(':scope > .comment-header > .comment-edited')) {
+            comment.querySelector(':scope > .comment-header').insertAdjacentHTML('beforeend',
+                `<span class="comment-edited" title="Edited ${formatDate(data.edited_at)}">(edited)</span>`);
+        }
+        editor.style.display = 'none';
+        content.style.display = '';
+        button.textContent = 'Edit';
+    } catch (error) {
+        console.error('Error editing comment:', error);
+        alert(`Error: ${error.message}`);
+    }
+}
+
+async function deleteComment(postId, commentId) {
+    if (!confirm('Delete this comment?')) return;
+    try {
+        const response = await fetch(`/api/comments/${commentId}`, {
+            method: 'DELETE',
+            credentials: 'include'
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Failed to delete comment');
+        }
+        await loadComments(postId);
+    } catch (error) {
+        console.error('Error deleting comment:', error);
+        alert(`Error: ${error.message}`);
+    }
+}
+
+// Permalink view of one comment: its post, then the comment with a few of
+// its ancestors and its replies. idAndQuery may carry ?context=N.
+async function fetchCommentThread(idAndQuery) {
+    const response = await fetch(`/api/comments/${idAndQuery}`);
+    const data = await response.json();
+    if (!response.ok) {
+        return `<p class="error-message">${escapeHTML(data.error || 'Comment not found')}</p>`;
+    }
+    return `
+        ${renderPost(data.post)}
+        <div class="comments-section comment-thread">
+            <p class="no-comments">Showing a single comment thread. Open the post's comments to see them all.</p>
+            ${renderComments(data.comments, data.comment_id)}
+        </div>
+    `;
+}
+
 function toggleReplyForm(commentId) {
     const replyForm = document.getElementById(`reply-form-${commentId}`);
     if (replyForm.style.display === 'none' || !replyForm.style.display) {
@@ -128,4 +284,7 @@ function toggleReplyForm(commentId) {
 
 // window.handleCommentLike = handleCommentLike;
 window.toggleCommentForm = toggleCommentForm;
+window.continueThread = continueThread;
+window.editComment = editComment;
+window.deleteComment = deleteComment;
 window.handleCommentSubmit = handleCommentSubmit;
\ No newline at end of file
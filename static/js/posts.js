@@ -3,10 +3,31 @@ function renderPost(post) {
     return `
         <div class="post" data-category="${p.categories}">
             <p class="posted-on">${p.createdAtHuman}</p>
-            <strong><p>${p.username}</p></strong>
+            <strong><p>${p.username} <span class="karma">${p.authorKarma} karma</span></p></strong>
             <h3>${p.title}</h3>
-            <p>${p.content}</p>
-            ${p.imagePath ? `<img src="${p.imagePath}" alt="Post Image" class="post-image">` : ''}
+            ${p.pinned || p.locked || p.archived ? `
+                <p class="post-badges">
+                    ${p.pinned ? '<span class="post-badge"><i class="fas fa-thumbtack"></i> Pinned</span>' : ''}
+                    ${p.locked ? '<span class="post-badge"><i class="fas fa-lock"></i> Locked</span>' : ''}
+                    ${p.archived ? '<span class="post-badge"><i class="fas fa-archive"></i> Archived</span>' : ''}
+                </p>
+            ` : ''}
+            ${p.nsfw || p.spoiler ? `
+                <p class="content-warnings">
+                    ${p.nsfw ? '<span class="content-warning">NSFW</span>' : ''}
+                    ${p.spoiler ? '<span class="content-warning">Spoiler</span>' : ''}
+                </p>
+            ` : ''}
+            <div class="${p.blur ? 'blurred' : ''}" onclick="this.classList.remove('blurred')">
+                <div class="post-content">${p.contentHTML || p.content}</div>
+                ${p.attachments.length > 0 ? `
+                    <div class="post-images">
+                        ${p.attachments.map(a => `<a href="/${a.ImagePath}" target="_blank"><img src="/${a.ThumbnailPath || a.ImagePath}" alt="${(a.AltText || 'Post image').replace(/"/g, '&quot;')}" class="post-image" loading="lazy"></a>`).join('')}
+                    </div>
+                ` : ''}
+            </div>
+            ${p.link ? renderLinkPreview(p.link) : ''}
+            ${p.poll ? renderPoll(p.id, p.poll) : ''}
             <p class="categories">Categories: <span>${p.categories}</span></p>
             <div class="post-actions">
                 <button class="like-button ${p.userLiked ? 'active' : ''}" data-post-id="${p.id}" onclick="handleLikeAction('${p.id}', true)">
@@ -18,19 +39,104 @@ function renderPost(post) {
                 <button class="comment-button" onclick="toggleCommentForm('${p.id}')">
                     <i class="fas fa-comment"></i> Comments
                 </button>
+                <button class="save-button ${p.saved ? 'active' : ''}" data-post-id="${p.id}" onclick="handleSaveAction('${p.id}')">
+                    <i class="fas fa-bookmark"></i> <span>${p.saved ? 'Saved' : 'Save'}</span>
+                </button>
+                <button class="hide-button" onclick="handleHideAction('${p.id}', this)">
+                    <i class="fas fa-eye-slash"></i> Hide
+                </button>
             </div>
             <div id="comment-form-${p.id}" style="display:none;" class="comment-form">
+                ${p.locked || p.archived ? `
+                <p class="no-comments">This post is ${p.locked ? 'locked' : 'archived'}; new comments and votes are closed.</p>
+                ` : `
                 <form onsubmit="return handleCommentSubmit('${p.id}', event)">
                     <textarea name="content" required placeholder="Write your comment..."></textarea>
                     <button type="submit">Post Comment</button>
                     <button type="button" onclick="toggleCommentForm('${p.id}')">Cancel</button>
                 </form>
+                `}
+                <label class="comment-sort">Sort by
+                    <select onchange="changeCommentSort('${p.id}', this.value)">
+                        <option value="">Default</option>
+                        <option value="best">Best</option>
+                        <option value="top">Top</option>
+                        <option value="new">New</option>
+                        <option value="old">Old</option>
+                        <option value="controversial">Controversial</option>
+                    </select>
+                </label>
             </div>
             <div id="comments-${p.id}" style="display:none;" class="comments-section"></div>
         </div>
     `;
 }
 
+function renderLinkPreview(link) {
+    return `
+        <a class="link-preview" href="${escapeHTML(link.url)}" target="_blank" rel="nofollow ugc noopener">
+            ${link.image_path ? `<img src="/${link.image_path}" alt="" loading="lazy">` : ''}
+            <div>
+                <strong>${escapeHTML(link.title || link.url)}</strong>
+                ${link.description ? `<p>${escapeHTML(link.description)}</p>` : ''}
+                <span class="link-domain">${escapeHTML(link.domain)}</span>
+            </div>
+        </a>
+    `;
+}
+
+function renderPoll(postId, poll) {
+    const canVote = !poll.has_voted && !poll.closed;
+    const inputType = poll.multiple_choice ? 'checkbox' : 'radio';
+    const status = poll.closed ? 'Poll closed' : (poll.closes_at ? `Closes ${formatDate(poll.closes_at)}` : 'Open');
+    const results = poll.results_visible ? `${poll.total_voters} voter(s)` : 'Results shown when the poll closes';
+    return `
+        <form class="poll" id="poll-${postId}" onsubmit="return handlePollVote('${postId}', event)">
+            ${poll.options.map(o => `
+                <label class="poll-option">
+                    ${canVote ? `<input type="${inputType}" name="option" value="${o.id}">` : ''}
+                    <span>${escapeHTML(o.label)}</span>
+                    ${poll.my_choices.includes(o.id) ? '<i class="fas fa-check"></i>' : ''}
+                    ${poll.results_visible ? `<span class="poll-votes">${o.votes}</span>` : ''}
+                </label>
+            `).join('')}
+            <p class="poll-status">${status} · ${results}</p>
+            ${canVote ? '<button type="submit">Vote</button>' : ''}
+        </form>
+    `;
+}
+
+async function handlePollVote(postId, event) {
+    event.preventDefault();
+    const form = event.target;
+    const optionIds = [...form.querySelectorAll('input[name="option"]:checked')].map(input => Number(input.value));
+    if (optionIds.length === 0) return false;
+
+    try {
+        const response = await fetch('/api/polls/vote', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ post_id: Number(postId), option_ids: optionIds })
+        });
+
+        if (response.status === 401) {
+            window.location.hash = '#/login';
+            return false;
+        }
+
+        const data = await response.json();
+        if (data.success) {
+            form.outerHTML = renderPoll(postId, data.poll);
+        } else {
+            alert(data.error || 'Failed to record your vote');
+        }
+    } catch (error) {
+        console.error('Poll vote failed:', error);
+        alert('An error occurred. Please try again.');
+    }
+    return false;
+}
+
 async function fetchHomeContent() {
     try {
         const response = await fetch('/api/home');
@@ -60,13 +166,14 @@ async function fetchHomeContent() {
                     </div>
                     ` : ''}
                     
-                    <h1 id="postsHeading">All Posts</h1>
+                    <h1 id="postsHeading">${feedHeadings[data.feed] || 'All Posts'}</h1>
                     <div id="posts">
                         ${posts.length > 0 ? 
                             posts.map(post => renderPost(post)).join('') :
                             '<p class="empty-message">No posts found</p>'
                         }
                     </div>
+                    ${data.has_more ? `<button id="load-more-posts" onclick="loadMoreHomePosts(${data.page + 1})">Load more</button>` : ''}
                 </main>
             </div>
         `;
@@ -76,6 +183,32 @@ async function fetchHomeContent() {
     }
 }
 
+const feedHeadings = {
+    personal: 'Your Feed',
+    popular: 'Popular Posts',
+    global: 'All Posts'
+};
+
+async function loadMoreHomePosts(page) {
+    const button = document.getElementById('load-more-posts');
+    if (button) button.disabled = true;
+    try {
+        const response = await fetch(`/api/home?page=${page}`);
+        if (!response.ok) throw new Error(`HTTP error! Status: ${response.status}`);
+        const data = await response.json();
+        document.getElementById('posts').insertAdjacentHTML('beforeend', (data.posts || []).map(post => renderPost(post)).join(''));
+        if (data.has_more) {
+            button.disabled = false;
+            button.setAttribute('onclick', `loadMoreHomePosts(${data.page + 1})`);
+        } else {
+            button.remove();
+        }
+    } catch (error) {
+        console.error('Error loading more posts:', error);
+        if (button) button.disabled = false;
+    }
+}
+
 async function fetchFilteredContent(category) {
     try {
         const response = await fetch(`/api/filter?category=${encodeURIComponent(category)}`);
@@ -109,8 +242,8 @@ async function fetchFilteredContent(category) {
                             <label for="post-content">Content:</label>
                             <textarea id="post-content" name="content" required></textarea>
                             <br>
-                            <label for="post-image">Image (optional, max 20MB):</label>
-                            <input type="file" id="post-image" name="image" accept="image/jpeg,image/png,image/gif">
+                            <label for="post-image">Images (optional, max 20MB each):</label>
+                            <input type="file" id="post-image" name="images" accept="image/jpeg,image/png,image/gif" multiple>
                             <br>
                             <label>Categories (select at least one):</label>
                             <div class="checkbox-group">
@@ -227,8 +360,13 @@ function toggleCreatePost() {
                 </div>
                 
                 <div class="form-group">
-                    <label for="post-image">Image (optional, max 20MB):</label>
-                    <input type="file" id="post-image" name="image" accept="image/*">
+                    <label for="post-image">Images (optional, max 20MB each):</label>
+                    <input type="file" id="post-image" name="images" accept="image/jpeg,image/png,image/gif" multiple>
+                </div>
+                
+                <div class="form-group">
+                    <label><input type="checkbox" name="nsfw" value="1"> NSFW</label>
+                    <label><input type="checkbox" name="spoiler" value="1"> Spoiler</label>
                 </div>
                 
                 <div class="form-group">
@@ -260,7 +398,7 @@ function toggleCreatePost() {
 }
 
 
-function updateLikeUI(postId, likeCount, dislikeCount, isLike) {
+function updateLikeUI(postId, likeCount, dislikeCount, vote) {
     const likeBtn = document.querySelector(`.like-button[data-post-id="${postId}"]`);
     const dislikeBtn = document.querySelector(`.dislike-button[data-post-id="${postId}"]`);
     
@@ -268,16 +406,9 @@ function updateLikeUI(postId, likeCount, dislikeCount, isLike) {
     if (likeBtn) likeBtn.querySelector('.like-count').textContent = likeCount;
     if (dislikeBtn) dislikeBtn.querySelector('.dislike-count').textContent = dislikeCount;
     
-    // Update active states
-    if (isLike !== undefined) {
-        if (isLike) {
-            likeBtn?.classList.add('active');
-            dislikeBtn?.classList.remove('active');
-        } else {
-            dislikeBtn?.classList.add('active');
-            likeBtn?.classList.remove('active');
-        }
-    }
+    // Update active states to the vote now in place
+    likeBtn?.classList.toggle('active', vote === 'up');
+    dislikeBtn?.classList.toggle('active', vote === 'down');
 }
 
 function normalizeLikePost(post) {
@@ -285,8 +416,8 @@ function normalizeLikePost(post) {
         ...post,
         likeCount: post.likeCount || post.LikeCount || 0,
         dislikeCount: post.dislikeCount || post.DislikeCount || 0,
-        userLiked: post.userLiked || false,
-        userDisliked: post.userDisliked || false
+        userLiked: post.userLiked || post.UserVote === 'up',
+        userDisliked: post.userDisliked || post.UserVote === 'down'
     };
 }
 
@@ -294,25 +425,33 @@ let likeProcessing = false;
 async function handleLikeAction(postId, isLike) {
     if (likeProcessing) return;
     likeProcessing = true;
-    
+
+    // Clicking the button of the vote already cast takes it back
+    const button = document.querySelector(`.${isLike ? 'like' : 'dislike'}-button[data-post-id="${postId}"]`);
+    const vote = button?.classList.contains('active') ? 'none' : (isLike ? 'up' : 'down');
+
     try {
         const response = await fetch('/api/like', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/x-www-form-urlencoded',
             },
-            body: `post_id=${postId}&is_like=${isLike}`
+            body: `post_id=${postId}&vote=${vote}`
         });
 
         if (response.status === 401) {
             window.location.hash = '#/login';
             return;
         }
+        if (!response.ok) {
+            alert(await response.text() || 'Failed to process like/dislike');
+            return;
+        }
 
         const data = await response.json();
         
         if (data.success) {
-            updateLikeUI(postId, data.like_count, data.dislike_count, isLike);
+            updateLikeUI(postId, data.like_count, data.dislike_count, data.vote);
         } else {
             alert(data.error || 'Failed to process like/dislike');
         }
@@ -324,9 +463,59 @@ async function handleLikeAction(postId, isLike) {
     }
 }
 
+async function handleSaveAction(postId) {
+    const button = document.querySelector(`.save-button[data-post-id="${postId}"]`);
+    const saved = button?.classList.contains('active');
+
+    try {
+        const response = await fetch(`/api/posts/${postId}/save`, { method: saved ? 'DELETE' : 'POST' });
+
+        if (response.status === 401) {
+            window.location.hash = '#/login';
+            return;
+        }
+
+        const data = await response.json();
+        if (data.success) {
+            button?.classList.toggle('active', data.saved);
+            if (button) button.querySelector('span').textContent = data.saved ? 'Saved' : 'Save';
+        } else {
+            alert(data.error || 'Failed to save post');
+        }
+    } catch (error) {
+        console.error('Save action failed:', error);
+        alert('An error occurred. Please try again.');
+    }
+}
+
+async function handleHideAction(postId, button) {
+    try {
+        const response = await fetch(`/api/posts/${postId}/hide`, { method: 'POST' });
+
+        if (response.status === 401) {
+            window.location.hash = '#/login';
+            return;
+        }
+
+        const data = await response.json();
+        if (data.success) {
+            button.closest('.post')?.remove();
+        } else {
+            alert(data.error || 'Failed to hide post');
+        }
+    } catch (error) {
+        console.error('Hide action failed:', error);
+        alert('An error occurred. Please try again.');
+    }
+}
+
 window.renderPost = renderPost;
+window.handleHideAction = handleHideAction;
+window.handleSaveAction = handleSaveAction;
 window.fetchHomeContent = fetchHomeContent;
 window.handleLikeAction = handleLikeAction;
+window.handlePollVote = handlePollVote;
+window.loadMoreHomePosts = loadMoreHomePosts;
 window.handlePostSubmit = handlePostSubmit;
 window.toggleCreatePost = toggleCreatePost;
 // window.validateCategories = validateCategories;
\ No newline at end of file
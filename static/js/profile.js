@@ -16,7 +16,7 @@ async function fetchProfileContent() {
             ? profileData.CreatedPosts.map(post => `
                 <article class="post">
                     <h3>${post.Title}</h3>
-                    <p class="post-content">${post.Content}</p>
+                    <div class="post-content">${post.ContentHTML || post.Content}</div>
                     ${post.ImagePath ? `<img src="${post.ImagePath}" alt="Post Image" class="post-image">` : ''}
                     <div class="post-meta">
                         ${post.Categories ? `<span class="categories"><i class="fas fa-tags"></i> ${post.Categories}</span>` : ''}
@@ -33,7 +33,7 @@ async function fetchProfileContent() {
             ? profileData.LikedPosts.map(post => `
                 <article class="post">
                     <h3>${post.Title}</h3>
-                    <p class="post-content">${post.Content}</p>
+                    <div class="post-content">${post.ContentHTML || post.Content}</div>
                     ${post.ImagePath ? `<img src="${post.ImagePath}" alt="Post Image" class="post-image">` : ''}
                     <div class="post-meta">
                         <span class="author"><i class="fas fa-user"></i> ${post.Username}</span>
@@ -57,6 +57,8 @@ async function fetchProfileContent() {
         <h1><i class="fas fa-user-circle"></i> ${profileData.Username}'s Profile</h1>
         <p><i class="fas fa-envelope"></i> ${profileData.Email}</p>
         ${profileData.Nickname ? `<p><i class="fas fa-smile"></i> Nickname: ${profileData.Nickname}</p>` : ''}
+        <p><i class="fas fa-users"></i> ${profileData.FollowerCount || 0} followers · ${profileData.FollowingCount || 0} following</p>
+        <p><i class="fas fa-star"></i> ${profileData.Karma || 0} karma · ${profileData.PostKarma || 0} from posts · ${profileData.CommentKarma || 0} from comments</p>
         ${profileData.FirstName || profileData.LastName 
             ? `<p><i class="fas fa-id-card"></i> Name: ${profileData.FirstName || ''} ${profileData.LastName || ''}</p>` 
             : ''}
@@ -115,6 +117,14 @@ async function fetchProfileContent() {
         <input type="text" name="last_name" value="${profileData.LastName || ''}">
     </label>
 
+    <label>
+        Notify me when someone @mentions me:
+        <select name="mention_notifications">
+            <option value="true" ${profileData.MentionNotifications ? "selected" : ""}>Yes</option>
+            <option value="false" ${profileData.MentionNotifications ? "" : "selected"}>No</option>
+        </select>
+    </label>
+
     <button type="submit">Update Profile</button>
 </form>
      
@@ -127,6 +137,36 @@ async function fetchProfileContent() {
     }
 }
 
+// fetchUserProfile shows another user's public profile, which is where
+// @mentions link to.
+async function fetchUserProfile(username) {
+    try {
+        const response = await fetch(`/api/users/${encodeURIComponent(username)}`);
+        const data = await response.json();
+        if (!response.ok || !data.success) {
+            return `<p class="error-message">${escapeHTML(data.error || 'User not found')}</p>`;
+        }
+        return `
+            <div class="profile-container">
+                <div class="profile-header">
+                    <div class="avatar">
+                        ${data.avatar_url
+                            ? `<img src="${escapeHTML(data.avatar_url)}" alt="Avatar" class="avatar-img">`
+                            : `<i class="fas fa-user-circle fa-4x"></i>`}
+                    </div>
+                    <h1><i class="fas fa-user-circle"></i> ${escapeHTML(data.username)}</h1>
+                    ${data.nickname ? `<p><i class="fas fa-smile"></i> Nickname: ${escapeHTML(data.nickname)}</p>` : ''}
+                    <p><i class="fas fa-users"></i> ${data.follower_count || 0} followers · ${data.following_count || 0} following</p>
+                    <p><i class="fas fa-star"></i> ${data.karma || 0} karma · ${data.post_karma || 0} from posts · ${data.comment_karma || 0} from comments</p>
+                </div>
+            </div>
+        `;
+    } catch (error) {
+        console.error('Error fetching user profile:', error);
+        return '<p class="error-message">Failed to load profile. Please try again.</p>';
+    }
+}
+
 // profile.js
 window.attachProfileFormHandler = function () {
     const form = document.getElementById("profile-update-form");
@@ -138,7 +178,13 @@ window.attachProfileFormHandler = function () {
         const payload = {};
         for (const [key, value] of formData.entries()) {
             if (value !== "") {
-                payload[key] = key === "age" ? parseInt(value, 10) : value;
+                if (key === "age") {
+                    payload[key] = parseInt(value, 10);
+                } else if (key === "mention_notifications") {
+                    payload[key] = value === "true";
+                } else {
+                    payload[key] = value;
+                }
             }
         }
 
@@ -1,4 +1,5 @@
 // Shared constants and utilities
+// Built-in communities, replaced by the server's list once loadCommunities runs
 const validCategories = [
     "technology",
     "general",
@@ -15,22 +16,57 @@ const validCategories = [
     "jobs"
 ];
 
+let communitiesLoaded = false;
+async function loadCommunities() {
+    if (communitiesLoaded) return;
+    try {
+        const response = await fetch('/api/communities');
+        const data = await response.json();
+        if (data.success) {
+            validCategories.splice(0, validCategories.length, ...data.communities.map(c => c.name));
+            communitiesLoaded = true;
+        }
+    } catch (error) {
+        console.error('Failed to load communities:', error);
+    }
+}
+
 function normalizePost(post) {
     return {
         id: post.id || post.ID,
         title: post.title || post.Title,
         content: post.content || post.Content,
+        contentHTML: post.contentHTML || post.ContentHTML,
         username: post.username || post.Username,
+        authorKarma: post.authorKarma || post.AuthorKarma || 0,
         categories: post.categories || post.Categories,
         imagePath: post.imagePath || post.ImagePath,
+        thumbnailPath: post.thumbnailPath || post.ThumbnailPath,
+        attachments: post.attachments || post.Attachments || [],
+        postType: post.postType || post.PostType || 'text',
+        poll: post.poll || post.Poll || null,
+        link: post.link || post.Link || null,
         likeCount: post.likeCount || post.LikeCount || 0,
         dislikeCount: post.dislikeCount || post.DislikeCount || 0,
-        userLiked: post.userLiked || false,
-        userDisliked: post.userDisliked || false,
+        userLiked: post.userLiked || post.UserVote === 'up',
+        userDisliked: post.userDisliked || post.UserVote === 'down',
+        saved: post.saved || post.Saved || false,
+        nsfw: post.nsfw || post.NSFW || false,
+        spoiler: post.spoiler || post.Spoiler || false,
+        blur: post.blur || post.Blur || false,
+        pinned: post.pinned || post.Pinned || false,
+        locked: post.locked || post.Locked || false,
+        archived: post.archived || post.Archived || false,
         createdAtHuman: post.createdAtHuman || post.CreatedAtHuman || formatDate(post.createdAt || post.CreatedAt)
     };
 }
 
+function escapeHTML(text) {
+    return String(text).replace(/[&<>"']/g, c => ({
+        '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'
+    }[c]));
+}
+
 function formatDate(dateString) {
     const date = new Date(dateString);
     return date.toLocaleDateString('en-US', { 